@@ -54,9 +54,20 @@ func init() {
 	viper.SetDefault("server.port", "9090")
 	viper.SetDefault("server.dhis2_job_status_check_interval", 15)
 	viper.SetDefault("server.request_process_interval", 5)
+	viper.SetDefault("server.request_sweep_interval", 60)
+	viper.SetDefault("server.request_lease_duration", 300)
+	viper.SetDefault("server.shutdown_drain_timeout", 30)
+	viper.SetDefault("server.idempotency_policy", "return_existing")
+	viper.SetDefault("server.http_connect_timeout", 10)
+	viper.SetDefault("server.http_read_timeout", 60)
 	viper.SetDefault("server.max_retries", 3)
 	viper.SetDefault("server.retry_cron_expression", "*/5 * * * *")
 	viper.SetDefault("server.timezone", "Africa/Kampala")
+	viper.SetDefault("server.command_timeout", 300)
+	viper.SetDefault("server.callback_retry_interval", 60)
+	viper.SetDefault("server.callback_max_attempts", 8)
+	viper.SetDefault("server.circuit_breaker_threshold", 5)
+	viper.SetDefault("server.circuit_breaker_cooldown", 60)
 
 	viper.SetConfigName("dispatcher2")
 	viper.SetConfigType("yaml")
@@ -157,15 +168,31 @@ type Config struct {
 		MaxConcurrent               int    `mapstructure:"max_concurrent" env:"DISPATCHER2_MAX_CONCURRENT" env-default:"5"`
 		RetryCronExpression         string `mapstructure:"retry_cron_expression"  env:"RETRY_CRON_EXPRESSION" env-description:"The request retry Cron Expression" env-default:"*/5 * * * *"`
 		RequestProcessInterval      int    `mapstructure:"request_process_interval" env:"REQUEST_PROCESS_INTERVAL" env-default:"4"`
+		RequestSweepInterval        int    `mapstructure:"request_sweep_interval" env:"REQUEST_SWEEP_INTERVAL" env-default:"60" env-description:"The seconds between sweeps of the queue for ready requests missed by notifications"`
+		RequestLeaseDuration        int    `mapstructure:"request_lease_duration" env:"REQUEST_LEASE_DURATION" env-default:"300" env-description:"The seconds a claim on a request holds before it is handed back to the queue"`
+		InstanceID                  string `mapstructure:"instance_id" env:"DISPATCHER2_INSTANCE_ID" env-description:"The name under which this dispatcher claims requests, the host name and process id by default"`
+		ShutdownDrainTimeout        int    `mapstructure:"shutdown_drain_timeout" env:"DISPATCHER2_SHUTDOWN_DRAIN_TIMEOUT" env-default:"30" env-description:"The seconds requests in flight are given to finish on shutdown"`
+		IdempotencyPolicy           string `mapstructure:"idempotency_policy" env:"DISPATCHER2_IDEMPOTENCY_POLICY" env-default:"return_existing" env-description:"What to do with a repeat submission: return_existing, reject or replace_pending"`
 		Dhis2JobStatusCheckInterval int    `mapstructure:"dhis2_job_status_check_interval" env:"DHIS2_JOB_STATUS_CHECK_INTERVAL" env-description:"The DHIS2 job status check interval in seconds" env-default:"30"`
 		LogDirectory                string `mapstructure:"logdir" env:"DISPATCHER2_LOGDIR" env-default:"/var/log/dispatcher2"`
 		UseSSL                      string `mapstructure:"use_ssl" env:"DISPATCHER2_USE_SSL" env-default:""`
 		SSLClientCertKeyFile        string `mapstructure:"ssl_client_certkey_file" env:"SSL_CLIENT_CERTKEY_FILE" env-default:""`
 		SSLServerCertKeyFile        string `mapstructure:"ssl_server_certkey_file" env:"SSL_SERVER_CERTKEY_FILE" env-default:""`
 		SSLTrustedCAFile            string `mapstructure:"ssl_trusted_cafile" env:"SSL_TRUSTED_CA_FILE" env-default:""`
+		HTTPConnectTimeout          int    `mapstructure:"http_connect_timeout" env:"DISPATCHER2_HTTP_CONNECT_TIMEOUT" env-default:"10" env-description:"The seconds allowed to connect to a server"`
+		HTTPReadTimeout             int    `mapstructure:"http_read_timeout" env:"DISPATCHER2_HTTP_READ_TIMEOUT" env-default:"60" env-description:"The seconds allowed for a server's response once connected"`
 		TimeZone                    string `mapstructure:"timezone" env:"DISPATCHER2_TIMEZONE" env-default:"Africa/Kampala" env-description:"The time zone used for this dispatcher2 deployment"`
+		CommandTimeout              int    `mapstructure:"command_timeout" env:"DISPATCHER2_COMMAND_TIMEOUT" env-default:"300" env-description:"The timeout in seconds for command schedules"`
+		CallbackSigningKey          string `mapstructure:"callback_signing_key" env:"DISPATCHER2_CALLBACK_SIGNING_KEY" env-description:"The key used to sign callbacks to source servers"`
+		CallbackRetryInterval       int    `mapstructure:"callback_retry_interval" env:"DISPATCHER2_CALLBACK_RETRY_INTERVAL" env-default:"60" env-description:"The seconds before the first retry of a failed callback, doubled on every retry"`
+		CallbackMaxAttempts         int    `mapstructure:"callback_max_attempts" env:"DISPATCHER2_CALLBACK_MAX_ATTEMPTS" env-default:"8" env-description:"The attempts made to deliver a callback before giving up"`
+		CircuitBreakerThreshold     int    `mapstructure:"circuit_breaker_threshold" env:"DISPATCHER2_CIRCUIT_BREAKER_THRESHOLD" env-default:"5" env-description:"The consecutive failures after which requests to a server are held back"`
+		CircuitBreakerCooldown      int    `mapstructure:"circuit_breaker_cooldown" env:"DISPATCHER2_CIRCUIT_BREAKER_COOLDOWN" env-default:"60" env-description:"The seconds requests to a failing server are held back before a trial request"`
 	} `yaml:"server"`
 
+	// Commands are the only commands command schedules can run, by name. e.g refresh-orgunits: /usr/local/bin/refresh-orgunits
+	Commands map[string]string `mapstructure:"commands" yaml:"commands"`
+
 	API struct {
 		Email     string `yaml:"email" env:"DISPATCHER2_EMAIL" env-description:"API user email address"`
 		AuthToken string `yaml:"authtoken" env:"RAPIDPRO_AUTH_TOKEN" env-description:"API JWT authorization token"`
@@ -199,8 +226,23 @@ type ServerConf struct {
 	EndOfSubmissionPeriod   int            `mapstructure:"endSubmissionPeriod" json:"endSubmissionPeriod"`
 	XMLResponseXPATH        string         `mapstructure:"XMLResponseXPATH"  json:"XMLResponseXPATH"`
 	JSONResponseXPATH       string         `mapstructure:"JSONResponseXPATH" json:"JSONResponseXPATH"`
+	ResponseFailureValues   pq.StringArray `mapstructure:"responseFailureValues" json:"responseFailureValues,omitempty"`
 	Suspended               bool           `mapstructure:"suspended" json:"suspended,omitempty"`
 	URLParams               map[string]any `mapstructure:"URLParams" json:"URLParams,omitempty"`
+	RetryBackoffBase        int            `mapstructure:"retryBackoffBase" json:"retryBackoffBase,omitempty"`
+	RetryBackoffMax         int            `mapstructure:"retryBackoffMax" json:"retryBackoffMax,omitempty"`
+	RetryBackoffJitter      *float64       `mapstructure:"retryBackoffJitter" json:"retryBackoffJitter,omitempty"`
+	RateLimit               float64        `mapstructure:"rateLimit" json:"rateLimit,omitempty"`
+	MaxInFlight             int            `mapstructure:"maxInFlight" json:"maxInFlight,omitempty"`
+	InsecureSkipVerify      bool           `mapstructure:"insecureSkipVerify" json:"insecureSkipVerify,omitempty"`
+	ConnectTimeout          int            `mapstructure:"connectTimeout" json:"connectTimeout,omitempty"`
+	ReadTimeout             int            `mapstructure:"readTimeout" json:"readTimeout,omitempty"`
+	OAuth2TokenURL          string         `mapstructure:"oauth2TokenURL" json:"oauth2TokenURL,omitempty"`
+	OAuth2ClientID          string         `mapstructure:"oauth2ClientID" json:"oauth2ClientID,omitempty"`
+	OAuth2ClientSecret      string         `mapstructure:"oauth2ClientSecret" json:"oauth2ClientSecret,omitempty"`
+	OAuth2Scopes            string         `mapstructure:"oauth2Scopes" json:"oauth2Scopes,omitempty"`
+	APIKeyHeader            string         `mapstructure:"apiKeyHeader" json:"apiKeyHeader,omitempty"`
+	HMACHeader              string         `mapstructure:"hmacHeader" json:"hmacHeader,omitempty"`
 	Created                 time.Time      `mapstructure:"created" json:"created,omitempty"`
 	Updated                 time.Time      `mapstructure:"updated" json:"updated,omitempty"`
 	AllowedSources          []string       `mapstructure:"allowedSources" json:"allowedSources,omitempty"`
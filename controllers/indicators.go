@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/models"
+	"go-dispatcher2/utils/dbutils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// IndicatorMappingController defines the methods for the mappings of report fields to DHIS 2 data elements
+type IndicatorMappingController struct{}
+
+// getIndicatorMapping reads the mapping in the path, answering with an error if there is none
+func getIndicatorMapping(c *gin.Context, db *sqlx.DB) (models.Dhis2IndicatorMapping, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return models.Dhis2IndicatorMapping{}, false
+	}
+	mapping, err := models.GetIndicatorMapping(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Indicator mapping not found"})
+		return mapping, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return mapping, false
+	}
+	return mapping, true
+}
+
+// indicatorMappingSaveError answers with the error saving a mapping, a conflict if the dataset already maps the slug
+func indicatorMappingSaveError(c *gin.Context, err error) {
+	if dbutils.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the dataset already has a mapping with this slug"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// ListIndicatorMappings returns all indicator mappings, as CSV with format=csv
+func (m *IndicatorMappingController) ListIndicatorMappings(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	mappings, err := models.ListIndicatorMappings(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, mappings)
+		return
+	}
+	var out bytes.Buffer
+	if err := models.ExportIndicatorMappings(&out, mappings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="indicator_mappings.csv"`)
+	c.Data(http.StatusOK, "text/csv", out.Bytes())
+}
+
+// NewIndicatorMapping creates an indicator mapping from the JSON body or imports those in a CSV body
+func (m *IndicatorMappingController) NewIndicatorMapping(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		imported, err := models.ImportIndicatorMappings(db, c.Request.Body)
+		if err != nil {
+			log.WithError(err).Error("Failed to import indicator mappings")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"imported": imported})
+		return
+	}
+
+	var mapping models.Dhis2IndicatorMapping
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mapping.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := models.CreateIndicatorMapping(db, mapping)
+	if err != nil {
+		indicatorMappingSaveError(c, err)
+		return
+	}
+	mapping, _ = models.GetIndicatorMapping(db, id)
+	c.JSON(http.StatusCreated, mapping)
+}
+
+// GetIndicatorMapping returns the indicator mapping with the id in the path
+func (m *IndicatorMappingController) GetIndicatorMapping(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	if mapping, ok := getIndicatorMapping(c, db); ok {
+		c.JSON(http.StatusOK, mapping)
+	}
+}
+
+// UpdateIndicatorMapping replaces the indicator mapping with the id in the path by the JSON body
+func (m *IndicatorMappingController) UpdateIndicatorMapping(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	mapping, ok := getIndicatorMapping(c, db)
+	if !ok {
+		return
+	}
+	id := mapping.ID
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mapping.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mapping.ID = id
+	if err := models.UpdateIndicatorMapping(db, mapping); err != nil {
+		indicatorMappingSaveError(c, err)
+		return
+	}
+	mapping, _ = models.GetIndicatorMapping(db, id)
+	c.JSON(http.StatusOK, mapping)
+}
+
+// DeleteIndicatorMapping deletes the indicator mapping with the id in the path
+func (m *IndicatorMappingController) DeleteIndicatorMapping(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	mapping, ok := getIndicatorMapping(c, db)
+	if !ok {
+		return
+	}
+	if err := models.DeleteIndicatorMapping(db, mapping.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -24,9 +25,20 @@ func (q *QueueController) Queue(c *gin.Context) {
 	contentType := c.Request.Header.Get("Content-Type")
 	// req, err := models.NewRequest(c, db)
 	req, err := models.NewRequestFromPOST(c, db)
+	if errors.Is(err, models.ErrDuplicateSubmission) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Submission already queued",
+			"uid":     req.UID(),
+			"status":  req.Status()})
+		return
+	}
+	if errors.Is(err, models.ErrInvalidRequest) {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Failed to add request to queue: %v", err))
+		return
+	}
 	if err != nil {
 		log.WithError(err).Error("Failed to add request to queue")
-		c.String(http.StatusBadGateway, "Failed to add request to queue")
+		c.String(http.StatusInternalServerError, "Failed to add request to queue")
 		return
 	}
 
@@ -179,3 +191,86 @@ func (q *QueueController) DeleteRequest(c *gin.Context) {
 		"status": "deleted"})
 	return
 }
+
+// DeadLetters method handles the /deadletters GET request listing requests that are no longer retried
+func (q *QueueController) DeadLetters(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var filter models.DeadLetterFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page := c.DefaultQuery("page", "1")
+	pageSize := c.DefaultQuery("pageSize", "50")
+	pager, deadLetters, err := models.ListDeadLetters(db, filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pager": pager, "deadLetters": deadLetters})
+}
+
+// ReplayRequest method handles the /queue/:id/replay POST request putting a dead letter back in the queue.
+// Only its failed CC servers are retried when failedCCServersOnly=true
+func (q *QueueController) ReplayRequest(c *gin.Context) {
+	filter := models.DeadLetterFilter{UID: c.Param("id")}
+	replayDeadLetters(c, filter, c.Query("failedCCServersOnly") == "true")
+}
+
+// ReplayDeadLettersRequest is the body of a bulk replay of dead letters
+type ReplayDeadLettersRequest struct {
+	models.DeadLetterFilter
+	FailedCCServersOnly bool `json:"failedCCServersOnly"`
+}
+
+// ReplayDeadLetters method handles the /deadletters/replay POST request replaying the dead letters matching
+// the filter in the body
+func (q *QueueController) ReplayDeadLetters(c *gin.Context) {
+	var body ReplayDeadLettersRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// a bulk replay of every dead letter is more likely a mistake than meant
+	if body.DeadLetterFilter.IsEmpty() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "filter the dead letters to replay by destination, statusCode, failureClass, since or until"})
+		return
+	}
+	replayDeadLetters(c, body.DeadLetterFilter, body.FailedCCServersOnly)
+}
+
+// replayDeadLetters replays the dead letters matching filter and records who replayed them in the audit log
+func replayDeadLetters(c *gin.Context, filter models.DeadLetterFilter, failedCCServersOnly bool) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	userID := c.MustGet("currentUser").(int64)
+	tx, err := db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	replayed, err := models.ReplayDeadLetters(tx, filter, failedCCServersOnly)
+	if err != nil {
+		_ = tx.Rollback()
+		if errors.Is(err, models.ErrNoDeadLetters) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to replay dead letters")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = models.AddAuditLog(tx, models.AuditLogRequests, "replay", userID, c.ClientIP(), gin.H{
+		"filter": filter, "uid": filter.UID, "failedCCServersOnly": failedCCServersOnly, "requests": replayed})
+	if err != nil {
+		_ = tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.WithFields(log.Fields{"userID": userID, "count": len(replayed)}).Info("Replayed dead letters")
+	c.JSON(http.StatusOK, gin.H{"status": "replayed", "count": len(replayed), "requests": replayed})
+}
@@ -1,89 +1,172 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
-	"go-dispatcher2/utils"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/models"
 )
 
+type dataValue struct {
+	DataElement         string `json:"dataElement"`
+	CategoryOptionCombo string `json:"categoryOptionCombo,omitempty"`
+	Value               string `json:"value"`
+}
+
 type dhis2Payload struct {
-	DataSet              string              `json:"dataSet"`
-	AttributeOptionCombo string              `json:"attributeOptionCombo"`
-	OrgUnit              string              `json:"orgUnit"`
-	Period               string              `json:"period"`
-	CompleteDate         string              `json:"completeDate,omitempty"`
-	DataValues           []map[string]string `josn:"dataValues"`
+	DataSet              string      `json:"dataSet"`
+	AttributeOptionCombo string      `json:"attributeOptionCombo,omitempty"`
+	OrgUnit              string      `json:"orgUnit"`
+	Period               string      `json:"period"`
+	CompleteDate         string      `json:"completeDate,omitempty"`
+	DataValues           []dataValue `json:"dataValues"`
 }
 
 type result struct {
-	Value    string `json:"value"`
-	Category string `json:"category"`
+	Value    interface{} `json:"value"`
+	Category string      `json:"category"`
+}
+
+type contact struct {
+	UUID   string                 `json:"uuid"`
+	Name   string                 `json:"name"`
+	URN    string                 `json:"urn"`
+	Fields map[string]interface{} `json:"fields"`
 }
 
+// postObject is the body of a RapidPro flow webhook
 type postObject struct {
-	Contact map[string]string `json:"contact"`
+	Contact contact           `json:"contact"`
 	Flow    map[string]string `json:"flow"`
 	Results map[string]result `json:"results"`
+	Run     map[string]string `json:"run"`
 }
 
-/*
-func getValue(obj map[string]interface{}, key string) string {
-	for k, v := range obj {
-		if k == key {
-			return v.(string)
+// getValue returns the value of a contact field as text
+func getValue(fields map[string]interface{}, key string) string {
+	for k, v := range fields {
+		if strings.EqualFold(k, key) && v != nil {
+			return strings.TrimSpace(fmt.Sprintf("%v", v))
 		}
 	}
 	return ""
 }
-*/
-
-func getValue(obj map[string]string, key string) string {
-	fmt.Printf("-----> %#v", obj)
-	if v, ok := obj[key]; ok {
-		return v
-	}
-	return ""
-}
 
 // RapidProController defines the rp-queue request methods
 type RapidProController struct{}
 
-// RapidProQueue method handles the /rp-queue request
+// RapidProQueue method handles the /rp-queue request. The results of the RapidPro flow are mapped to the data
+// elements of the dataset by their indicator mappings and queued as a DHIS 2 dataValueSet for the destination.
+// The orgUnit and period are read from the contact's fields named by orgUnitField and periodField, the period
+// falling back to the period parameter. The attributeOptionCombo parameter falls back to that of the mappings.
 func (r *RapidProController) RapidProQueue(c *gin.Context) {
-
 	db := c.MustGet("dbConn").(*sqlx.DB)
-	_, err := db.Queryx(`SELECT 1`)
-	if err != nil {
-		fmt.Printf("%v", err)
-	}
-	fmt.Printf("Got The request from DB => %v", utils.GetUID())
-
-	var payload dhis2Payload
 
 	var postObj postObject
-
-	if err := c.ShouldBind(&postObj); err != nil {
+	if err := c.ShouldBindJSON(&postObj); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// fmt.Printf("==========> %v", postObj)
-	contact := postObj.Contact
-	fmt.Printf("\n%v\n", getValue(contact, "urn"))
-	payload.OrgUnit = getValue(contact, "orgunit")
-
-	// assume the flow object passed the dataSet
-	flow := postObj.Flow
-	payload.DataSet = getValue(flow, "dataset")
-	payload.AttributeOptionCombo = getValue(flow, "attributeOptionCombo")
+	payload := dhis2Payload{
+		DataSet:              c.Query("dataset"),
+		AttributeOptionCombo: c.Query("attributeOptionCombo"),
+		OrgUnit:              getValue(postObj.Contact.Fields, c.DefaultQuery("orgUnitField", "orgunit")),
+		Period:               getValue(postObj.Contact.Fields, c.DefaultQuery("periodField", "period")),
+	}
+	if len(payload.Period) == 0 {
+		payload.Period = c.Query("period")
+	}
+	switch {
+	case len(payload.DataSet) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dataset parameter is required"})
+		return
+	case len(payload.OrgUnit) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "contact has no orgUnit field"})
+		return
+	case len(payload.Period) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "contact has no period field and no period parameter was given"})
+		return
+	}
+	year, week, month, err := models.PeriodDate(payload.Period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// payload.DataValues = append(payload.DataValues, map[string]string{"name": "seki"})
 	// Use indicator mapping to find variables that are allowed
-	fmt.Printf("%#v", payload)
+	mappings, err := models.GetDataSetIndicatorMappings(db, payload.DataSet)
+	if err != nil {
+		log.WithError(err).Error("Failed to get indicator mappings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	keys := make([]string, 0, len(postObj.Results))
+	for key := range postObj.Results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var unmapped []string
+	for _, key := range keys {
+		res := postObj.Results[key]
+		mapping, ok := mappings[strings.ToLower(key)]
+		if !ok || res.Value == nil {
+			unmapped = append(unmapped, key)
+			continue
+		}
+		payload.DataValues = append(payload.DataValues, dataValue{
+			DataElement:         mapping.DataElement,
+			CategoryOptionCombo: mapping.CategoryOptionCombo,
+			Value:               fmt.Sprintf("%v", res.Value),
+		})
+		if len(payload.AttributeOptionCombo) == 0 {
+			payload.AttributeOptionCombo = mapping.AttributeOptionCombo
+		}
+	}
+	if len(payload.DataValues) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no flow results are mapped to the dataset", "unmapped": unmapped})
+		return
+	}
+	if len(unmapped) > 0 {
+		log.WithFields(log.Fields{"flow": postObj.Flow["name"], "unmapped": unmapped}).Info("Skipped unmapped flow results")
+	}
 
-	c.JSON(http.StatusOK, payload)
-	return
+	body, _ := json.Marshal(payload)
+	reqF := models.RequestForm{
+		Source:       c.DefaultQuery("source", "rapidpro"),
+		Destination:  c.Query("destination"),
+		ContentType:  "application/json",
+		Body:         string(body),
+		Period:       payload.Period,
+		Year:         fmt.Sprintf("%d", year),
+		Week:         fmt.Sprintf("%d", week),
+		Month:        fmt.Sprintf("%d", month),
+		Facility:     payload.OrgUnit,
+		MSISDN:       strings.TrimPrefix(postObj.Contact.URN, "tel:"),
+		ReportType:   postObj.Flow["name"],
+		ObjectType:   "DATA_VALUES",
+		SubmissionID: postObj.Run["uuid"], // so that the same run is only queued once
+	}
+	req, err := reqF.Save(db)
+	if errors.Is(err, models.ErrDuplicateSubmission) {
+		c.JSON(http.StatusConflict, gin.H{"message": "Submission already queued", "uid": req.UID(), "status": req.Status()})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to add RapidPro request to queue")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"uid":      req.UID(),
+		"status":   req.Status(),
+		"payload":  payload,
+		"unmapped": unmapped})
 }
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"go-dispatcher2/models"
+	"go-dispatcher2/utils/dbutils"
+	"net/http"
+	"strconv"
+)
+
+// RouteController defines the methods for the transforms of routes between servers
+type RouteController struct{}
+
+// routeID reads the id of the route in the path, answering with an error if it is invalid
+func routeID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// getRoute reads the route in the path, answering with an error if there is none
+func getRoute(c *gin.Context, db *sqlx.DB) (models.Route, bool) {
+	id, ok := routeID(c)
+	if !ok {
+		return models.Route{}, false
+	}
+	route, err := models.GetRoute(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return route, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return route, false
+	}
+	return route, true
+}
+
+// routeSaveError answers with the error saving a route, a conflict if the source already has a route to the destination
+func routeSaveError(c *gin.Context, err error) {
+	if dbutils.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the source already has a route to this destination"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// ListRoutes returns all routes
+func (r *RouteController) ListRoutes(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	routes, err := models.ListRoutes(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, routes)
+}
+
+// NewRoute creates a route from the JSON body
+func (r *RouteController) NewRoute(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	route := models.Route{IsActive: true}
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := route.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := models.CreateRoute(db, route)
+	if err != nil {
+		routeSaveError(c, err)
+		return
+	}
+	if route, err = models.GetRoute(db, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, route)
+}
+
+// GetRoute returns the route with the id in the path
+func (r *RouteController) GetRoute(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	if route, ok := getRoute(c, db); ok {
+		c.JSON(http.StatusOK, route)
+	}
+}
+
+// UpdateRoute replaces the route with the id in the path by the JSON body
+func (r *RouteController) UpdateRoute(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	route, ok := getRoute(c, db)
+	if !ok {
+		return
+	}
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := route.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	route.ID, _ = routeID(c)
+	if err := models.UpdateRoute(db, route); err != nil {
+		routeSaveError(c, err)
+		return
+	}
+	route, err := models.GetRoute(db, route.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, route)
+}
+
+// DeleteRoute deletes the route with the id in the path
+func (r *RouteController) DeleteRoute(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := routeID(c)
+	if !ok {
+		return
+	}
+	if err := models.DeleteRoute(db, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// DryRun returns the body posted as the route with the id in the path would send it, without queueing it
+func (r *RouteController) DryRun(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	route, ok := getRoute(c, db)
+	if !ok {
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(route.Transforms) > 0 && !models.IsJSONContentType(c.ContentType()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "route transforms only apply to JSON bodies"})
+		return
+	}
+	transformed, err := route.Transforms.Apply(string(body))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	var out interface{} = transformed // a route without transforms passes any body through
+	if json.Valid([]byte(transformed)) {
+		out = json.RawMessage(transformed)
+	}
+	c.JSON(http.StatusOK, gin.H{"route": route.ID, "body": out})
+}
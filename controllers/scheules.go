@@ -20,6 +20,12 @@ func (s *ScheduleController) NewSchedule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error SCHED-001": err.Error()})
 		return
 	}
+	if schedule.ScheduleType == "command" {
+		if _, err := models.CommandPath(schedule.Command); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error SCHED-003": err.Error()})
+			return
+		}
+	}
 	schedule.Created = time.Now().In(models.Location)
 	schedule.Updated = time.Now().In(models.Location)
 	id, err := models.CreateSchedule(db, schedule)
@@ -54,6 +60,25 @@ func (s *ScheduleController) GetSchedule(c *gin.Context) {
 	c.JSON(http.StatusOK, schedule)
 }
 
+// ListScheduleRuns returns the paginated run history of a schedule, most recent first
+func (s *ScheduleController) ListScheduleRuns(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	page := c.DefaultQuery("page", "1")
+	pageSize := c.DefaultQuery("pageSize", "50")
+	pager, runs, err := models.GetScheduleRuns(db, id, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pager": pager, "runs": runs})
+}
+
 // DeleteSchedule deletes a schedule given id is params
 func (s *ScheduleController) DeleteSchedule(c *gin.Context) {
 	db := c.MustGet("dbConn").(*sqlx.DB)
@@ -86,6 +111,12 @@ func (s *ScheduleController) UpdateSchedule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if schedule.ScheduleType == "command" {
+		if _, err := models.CommandPath(schedule.Command); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	schedule.ID = id
 	err = models.UpdateSchedule(db, schedule)
 	if err != nil {
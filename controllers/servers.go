@@ -60,3 +60,8 @@ func (s *ServerController) ImportServers(c *gin.Context) {
 		"importSumary": summary,
 	})
 }
+
+// CircuitBreakers returns the state of the circuit breakers of the servers requests have been sent to
+func (s *ServerController) CircuitBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, models.CircuitBreakerStatuses())
+}
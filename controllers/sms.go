@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/models"
+)
+
+// smsReport is a raw SMS report as received from an SMS gateway
+type smsReport struct {
+	MSISDN      string `form:"msisdn" json:"msisdn"`
+	Message     string `form:"message" json:"message"`
+	Facility    string `form:"facility" json:"facility"`       // the orgUnit reported for
+	Period      string `form:"period" json:"period"`           // defaults to the previous ISO week
+	Source      string `form:"source" json:"source"`           // defaults to "sms"
+	Destination string `form:"destination" json:"destination"` // the DHIS 2 server
+}
+
+// SMSController defines the sms-queue request methods
+type SMSController struct{}
+
+// SMSQueue method handles the /sms-queue request. The raw SMS report is parsed by the indicator mappings of its
+// keyword and queued as a DHIS 2 dataValueSet for the destination. The reply text for the reporter is returned,
+// describing what was wrong with the report when it is rejected.
+func (s *SMSController) SMSQueue(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	var sms smsReport
+	if err := c.ShouldBind(&sms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case len(sms.MSISDN) == 0 || len(strings.TrimSpace(sms.Message)) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "msisdn and message are required"})
+		return
+	case len(sms.Facility) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "facility is required"})
+		return
+	}
+
+	blacklisted, err := models.IsBlacklisted(db, sms.MSISDN)
+	if err != nil {
+		log.WithError(err).Error("Failed to check blacklist")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if blacklisted {
+		log.WithField("msisdn", sms.MSISDN).Info("Refused SMS report from blacklisted number")
+		c.JSON(http.StatusForbidden, gin.H{"reply": "Your number is not allowed to send reports"})
+		return
+	}
+
+	report, err := models.ParseSMSReport(db, sms.Message)
+	if models.IsSMSReportError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"reply": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to parse SMS report")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(sms.Period) == 0 {
+		year, week := time.Now().AddDate(0, 0, -7).ISOWeek()
+		sms.Period = fmt.Sprintf("%dW%d", year, week)
+	}
+	year, week, month, err := models.PeriodDate(sms.Period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload := dhis2Payload{
+		DataSet:              report.DataSet,
+		AttributeOptionCombo: report.Values[0].Mapping.AttributeOptionCombo,
+		OrgUnit:              sms.Facility,
+		Period:               sms.Period,
+	}
+	for _, v := range report.Values {
+		payload.DataValues = append(payload.DataValues, dataValue{
+			DataElement:         v.Mapping.DataElement,
+			CategoryOptionCombo: v.Mapping.CategoryOptionCombo,
+			Value:               v.Value,
+		})
+	}
+
+	body, _ := json.Marshal(payload)
+	source := sms.Source
+	if len(source) == 0 {
+		source = "sms"
+	}
+	reqF := models.RequestForm{
+		Source:      source,
+		Destination: sms.Destination,
+		ContentType: "application/json",
+		Body:        string(body),
+		Period:      sms.Period,
+		Year:        fmt.Sprintf("%d", year),
+		Week:        fmt.Sprintf("%d", week),
+		Month:       fmt.Sprintf("%d", month),
+		Facility:    sms.Facility,
+		MSISDN:      sms.MSISDN,
+		RawMsg:      sms.Message,
+		ReportType:  report.Keyword,
+		ObjectType:  "DATA_VALUES",
+	}
+	req, err := reqF.Save(db)
+	if err != nil {
+		log.WithError(err).Error("Failed to add SMS report to queue")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"uid":    req.UID(),
+		"status": req.Status(),
+		"reply":  fmt.Sprintf("Thank you. Your %s report for %s was received", report.Keyword, sms.Period),
+	})
+}
@@ -0,0 +1,2 @@
+ALTER TABLE schedules DROP COLUMN IF EXISTS response;
+ALTER TABLE schedules DROP COLUMN IF EXISTS statuscode;
@@ -0,0 +1,2 @@
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS statuscode TEXT NOT NULL DEFAULT '';
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS response TEXT NOT NULL DEFAULT ''; -- response from last call to sched_url
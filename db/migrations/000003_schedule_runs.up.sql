@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS schedule_runs(
+    id bigserial NOT NULL PRIMARY KEY,
+    schedule_id BIGINT NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+    started TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished TIMESTAMPTZ,
+    exit_code INTEGER, -- for command schedules
+    stdout TEXT NOT NULL DEFAULT '',
+    stderr TEXT NOT NULL DEFAULT '',
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS schedule_runs_schedule_id ON schedule_runs(schedule_id);
+CREATE INDEX IF NOT EXISTS schedule_runs_started ON schedule_runs(started);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS schedule_runs_outcome;
+ALTER TABLE schedule_runs DROP COLUMN IF EXISTS errors;
+ALTER TABLE schedule_runs DROP COLUMN IF EXISTS response;
+ALTER TABLE schedule_runs DROP COLUMN IF EXISTS outcome;
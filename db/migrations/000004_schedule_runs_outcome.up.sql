@@ -0,0 +1,4 @@
+ALTER TABLE schedule_runs ADD COLUMN IF NOT EXISTS outcome TEXT NOT NULL DEFAULT '';
+ALTER TABLE schedule_runs ADD COLUMN IF NOT EXISTS response TEXT NOT NULL DEFAULT ''; -- excerpt of the response
+ALTER TABLE schedule_runs ADD COLUMN IF NOT EXISTS errors TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS schedule_runs_outcome ON schedule_runs(outcome);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS request_callbacks;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS request_callbacks(
+    id bigserial NOT NULL PRIMARY KEY,
+    request_id BIGINT NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+    server_id INTEGER NOT NULL REFERENCES servers(id) ON DELETE CASCADE, -- the source server notified
+    url TEXT NOT NULL DEFAULT '',
+    request_status TEXT NOT NULL DEFAULT '', -- the request status the callback notifies of
+    summary TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    statuscode TEXT NOT NULL DEFAULT '',
+    errors TEXT NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (request_id, request_status)
+);
+CREATE INDEX IF NOT EXISTS request_callbacks_due ON request_callbacks(status, next_attempt_at);
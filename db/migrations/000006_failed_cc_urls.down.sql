@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS has_failed_cc_urls(jsonb);
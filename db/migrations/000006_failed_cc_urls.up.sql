@@ -0,0 +1,8 @@
+-- whether any copy of a request to a CC URL, kept under a 'url:' key in cc_servers_status, has failed
+CREATE OR REPLACE FUNCTION has_failed_cc_urls(servers_status jsonb) RETURNS boolean AS
+$delim$
+    SELECT EXISTS (
+        SELECT 1 FROM jsonb_each(CASE WHEN jsonb_typeof(servers_status) = 'object' THEN servers_status ELSE '{}'::jsonb END) AS s(key, value)
+        WHERE s.key LIKE 'url:%' AND s.value->>'status' <> 'completed'
+    );
+$delim$ LANGUAGE sql STABLE;
@@ -0,0 +1,37 @@
+CREATE OR REPLACE FUNCTION has_failed_cc_urls(servers_status jsonb) RETURNS boolean AS
+$delim$
+    SELECT EXISTS (
+        SELECT 1 FROM jsonb_each(CASE WHEN jsonb_typeof(servers_status) = 'object' THEN servers_status ELSE '{}'::jsonb END) AS s(key, value)
+        WHERE s.key LIKE 'url:%' AND s.value->>'status' <> 'completed'
+    );
+$delim$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION failed_cc_servers(servers integer[], servers_status jsonb) RETURNS integer[] AS
+$delim$
+DECLARE
+    i integer;
+    failed_servers integer[] := '{}'::int[];
+    status_code text;
+    status text;
+BEGIN
+    IF array_length(servers, 1) IS NOT NULL THEN
+        FOR i IN array_lower(servers, 1) .. array_upper(servers, 1) LOOP
+                status_code := servers_status->((servers)[i])::text->>'statusCode';
+                status := servers_status->((servers)[i])::text->>'status';
+                IF status_code LIKE '4%' OR status_code LIKE '5%' OR status = '' THEN
+                    failed_servers := array_append(failed_servers, servers[i]);
+                END IF;
+
+            END LOOP;
+    END IF;
+
+    RETURN failed_servers;
+END;
+$delim$ LANGUAGE plpgsql;
+
+ALTER TABLE servers DROP COLUMN IF EXISTS retry_backoff_jitter;
+ALTER TABLE servers DROP COLUMN IF EXISTS retry_backoff_max;
+ALTER TABLE servers DROP COLUMN IF EXISTS retry_backoff_base;
+
+DROP INDEX IF EXISTS requests_next_attempt_at;
+ALTER TABLE requests DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,42 @@
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ; -- when a failed request is next retried
+CREATE INDEX IF NOT EXISTS requests_next_attempt_at ON requests(next_attempt_at);
+
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS retry_backoff_base INTEGER NOT NULL DEFAULT 60; -- seconds
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS retry_backoff_max INTEGER NOT NULL DEFAULT 3600; -- seconds
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS retry_backoff_jitter DOUBLE PRECISION NOT NULL DEFAULT 0.2;
+
+-- failed cc servers whose next attempt, if set, is due
+CREATE OR REPLACE FUNCTION failed_cc_servers(servers integer[], servers_status jsonb) RETURNS integer[] AS
+$delim$
+DECLARE
+    i integer;
+    failed_servers integer[] := '{}'::int[];
+    status_code text;
+    status text;
+    next_attempt text;
+BEGIN
+    IF array_length(servers, 1) IS NOT NULL THEN
+        FOR i IN array_lower(servers, 1) .. array_upper(servers, 1) LOOP
+                status_code := servers_status->((servers)[i])::text->>'statusCode';
+                status := servers_status->((servers)[i])::text->>'status';
+                next_attempt := servers_status->((servers)[i])::text->>'nextAttemptAt';
+                IF (status_code LIKE '4%' OR status_code LIKE '5%' OR status = '' OR status = 'failed')
+                    AND (COALESCE(next_attempt, '') = '' OR next_attempt::timestamptz <= now()) THEN
+                    failed_servers := array_append(failed_servers, servers[i]);
+                END IF;
+
+            END LOOP;
+    END IF;
+
+    RETURN failed_servers;
+END;
+$delim$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION has_failed_cc_urls(servers_status jsonb) RETURNS boolean AS
+$delim$
+    SELECT EXISTS (
+        SELECT 1 FROM jsonb_each(CASE WHEN jsonb_typeof(servers_status) = 'object' THEN servers_status ELSE '{}'::jsonb END) AS s(key, value)
+        WHERE s.key LIKE 'url:%' AND s.value->>'status' <> 'completed'
+            AND (COALESCE(s.value->>'nextAttemptAt', '') = '' OR (s.value->>'nextAttemptAt')::timestamptz <= now())
+    );
+$delim$ LANGUAGE sql STABLE;
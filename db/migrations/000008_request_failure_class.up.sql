@@ -0,0 +1,38 @@
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS failure_class TEXT NOT NULL DEFAULT ''; -- class of the last failure
+
+-- failed cc servers whose next attempt, if set, is due. cc servers in error have failed permanently
+CREATE OR REPLACE FUNCTION failed_cc_servers(servers integer[], servers_status jsonb) RETURNS integer[] AS
+$delim$
+DECLARE
+    i integer;
+    failed_servers integer[] := '{}'::int[];
+    status_code text;
+    status text;
+    next_attempt text;
+BEGIN
+    IF array_length(servers, 1) IS NOT NULL THEN
+        FOR i IN array_lower(servers, 1) .. array_upper(servers, 1) LOOP
+                status_code := servers_status->((servers)[i])::text->>'statusCode';
+                status := servers_status->((servers)[i])::text->>'status';
+                next_attempt := servers_status->((servers)[i])::text->>'nextAttemptAt';
+                IF (status_code LIKE '4%' OR status_code LIKE '5%' OR status = '' OR status = 'failed')
+                    AND COALESCE(status, '') <> 'error'
+                    AND (COALESCE(next_attempt, '') = '' OR next_attempt::timestamptz <= now()) THEN
+                    failed_servers := array_append(failed_servers, servers[i]);
+                END IF;
+
+            END LOOP;
+    END IF;
+
+    RETURN failed_servers;
+END;
+$delim$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION has_failed_cc_urls(servers_status jsonb) RETURNS boolean AS
+$delim$
+    SELECT EXISTS (
+        SELECT 1 FROM jsonb_each(CASE WHEN jsonb_typeof(servers_status) = 'object' THEN servers_status ELSE '{}'::jsonb END) AS s(key, value)
+        WHERE s.key LIKE 'url:%' AND s.value->>'status' NOT IN ('completed', 'error')
+            AND (COALESCE(s.value->>'nextAttemptAt', '') = '' OR (s.value->>'nextAttemptAt')::timestamptz <= now())
+    );
+$delim$ LANGUAGE sql STABLE;
@@ -0,0 +1,2 @@
+ALTER TABLE servers DROP COLUMN IF EXISTS max_in_flight;
+ALTER TABLE servers DROP COLUMN IF EXISTS rate_limit;
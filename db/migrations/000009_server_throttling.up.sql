@@ -0,0 +1,2 @@
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS rate_limit DOUBLE PRECISION NOT NULL DEFAULT 0; -- requests per second, 0 for no limit
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS max_in_flight INTEGER NOT NULL DEFAULT 0; -- requests sent at once, 0 for no limit
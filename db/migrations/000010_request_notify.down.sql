@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS after_request_status_update_trigger ON requests;
+DROP FUNCTION IF EXISTS after_request_status_update_trigger_function();
+
+CREATE OR REPLACE FUNCTION after_request_insert_trigger_function()
+    RETURNS TRIGGER AS $$
+BEGIN
+    -- Call the generate_json_objects function with the inserted array
+    UPDATE requests SET cc_servers_status =  create_requests_cc_status(NEW.cc_servers)
+    WHERE id = NEW.id;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,34 @@
+-- notify the dispatcher of new requests that are ready to send
+CREATE OR REPLACE FUNCTION after_request_insert_trigger_function()
+    RETURNS TRIGGER AS $$
+BEGIN
+    -- Call the generate_json_objects function with the inserted array
+    UPDATE requests SET cc_servers_status =  create_requests_cc_status(NEW.cc_servers)
+    WHERE id = NEW.id;
+
+    IF NEW.status = 'ready' THEN
+        PERFORM pg_notify('dispatcher2_requests', NEW.id::text);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- notify the dispatcher of requests put back in the queue and of those waiting on a request that completed
+CREATE OR REPLACE FUNCTION after_request_status_update_trigger_function()
+    RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.status = 'ready' THEN
+        PERFORM pg_notify('dispatcher2_requests', NEW.id::text);
+    ELSIF NEW.status = 'completed' THEN
+        PERFORM pg_notify('dispatcher2_requests', r.id::text) FROM requests r
+        WHERE r.depends_on = NEW.id AND r.status = 'ready';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER after_request_status_update_trigger
+    AFTER UPDATE OF status ON requests
+    FOR EACH ROW
+    WHEN (OLD.status IS DISTINCT FROM NEW.status)
+EXECUTE FUNCTION after_request_status_update_trigger_function();
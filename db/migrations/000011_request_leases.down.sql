@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION after_request_status_update_trigger_function()
+    RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.status = 'ready' THEN
+        PERFORM pg_notify('dispatcher2_requests', NEW.id::text);
+    ELSIF NEW.status = 'completed' THEN
+        PERFORM pg_notify('dispatcher2_requests', r.id::text) FROM requests r
+        WHERE r.depends_on = NEW.id AND r.status = 'ready';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE requests SET status = 'ready' WHERE status = 'inprogress';
+DROP INDEX IF EXISTS requests_lease_expires_at;
+ALTER TABLE requests DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE requests DROP COLUMN IF EXISTS locked_by;
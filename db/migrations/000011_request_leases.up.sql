@@ -0,0 +1,17 @@
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS locked_by TEXT; -- the dispatcher instance processing the request
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ; -- when an unfinished claim is given up
+CREATE INDEX IF NOT EXISTS requests_lease_expires_at ON requests(lease_expires_at) WHERE status = 'inprogress';
+
+-- requests released unprocessed by a dispatcher are left to the next sweep rather than claimed again at once
+CREATE OR REPLACE FUNCTION after_request_status_update_trigger_function()
+    RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.status = 'ready' AND OLD.status <> 'inprogress' THEN
+        PERFORM pg_notify('dispatcher2_requests', NEW.id::text);
+    ELSIF NEW.status = 'completed' THEN
+        PERFORM pg_notify('dispatcher2_requests', r.id::text) FROM requests r
+        WHERE r.depends_on = NEW.id AND r.status = 'ready';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+ALTER TABLE servers DROP COLUMN IF EXISTS read_timeout;
+ALTER TABLE servers DROP COLUMN IF EXISTS connect_timeout;
+ALTER TABLE servers DROP COLUMN IF EXISTS insecure_skip_verify;
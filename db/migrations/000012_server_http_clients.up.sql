@@ -0,0 +1,3 @@
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS insecure_skip_verify BOOLEAN NOT NULL DEFAULT FALSE; -- don't verify the server's certificate
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS connect_timeout INTEGER NOT NULL DEFAULT 0; -- seconds, 0 for the global default
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS read_timeout INTEGER NOT NULL DEFAULT 0; -- seconds, 0 for the global default
@@ -0,0 +1,6 @@
+ALTER TABLE servers DROP COLUMN IF EXISTS hmac_header;
+ALTER TABLE servers DROP COLUMN IF EXISTS api_key_header;
+ALTER TABLE servers DROP COLUMN IF EXISTS oauth2_scopes;
+ALTER TABLE servers DROP COLUMN IF EXISTS oauth2_client_secret;
+ALTER TABLE servers DROP COLUMN IF EXISTS oauth2_client_id;
+ALTER TABLE servers DROP COLUMN IF EXISTS oauth2_token_url;
@@ -0,0 +1,6 @@
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS oauth2_token_url TEXT NOT NULL DEFAULT ''; -- where OAuth2 client credentials are exchanged for tokens
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS oauth2_client_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS oauth2_client_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS oauth2_scopes TEXT NOT NULL DEFAULT ''; -- space separated
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS api_key_header TEXT NOT NULL DEFAULT ''; -- header carrying the auth_token as an API key
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS hmac_header TEXT NOT NULL DEFAULT ''; -- header carrying the HMAC signature keyed with the auth_token
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS requests_source_submissionid;
@@ -0,0 +1,4 @@
+-- a source's submission is queued once. Earlier duplicates keep the first request's submission id
+UPDATE requests r SET submissionid = '' FROM requests o
+WHERE r.submissionid <> '' AND o.source = r.source AND o.submissionid = r.submissionid AND o.id < r.id;
+CREATE UNIQUE INDEX IF NOT EXISTS requests_source_submissionid ON requests(source, submissionid) WHERE submissionid <> '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS routes(
+    id bigserial NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    source INTEGER NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    destination INTEGER NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    transforms JSONB NOT NULL DEFAULT '[]'::JSONB, -- applied in order to bodies sent from source to destination
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(source, destination)
+);
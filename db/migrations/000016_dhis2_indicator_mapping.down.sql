@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dhis2_indicator_mapping;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS dhis2_indicator_mapping(
+    id bigserial NOT NULL PRIMARY KEY,
+    uid VARCHAR(11) NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    form TEXT NOT NULL DEFAULT '',
+    slug TEXT NOT NULL DEFAULT '', -- the key of the flow result or message field carrying the value
+    cmd TEXT NOT NULL DEFAULT '',
+    form_order TEXT NOT NULL DEFAULT '',
+    dataelement TEXT NOT NULL DEFAULT '',
+    category_option_combo TEXT NOT NULL DEFAULT '',
+    dataset TEXT NOT NULL DEFAULT '',
+    attribute_option_combo TEXT NOT NULL DEFAULT '',
+    category_combo TEXT NOT NULL DEFAULT '',
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS dhis2_indicator_mapping_dataset_slug ON dhis2_indicator_mapping(dataset, LOWER(slug));
+CREATE INDEX IF NOT EXISTS dhis2_indicator_mapping_form ON dhis2_indicator_mapping(form);
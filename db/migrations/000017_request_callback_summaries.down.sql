@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS request_callbacks_request_status_summary;
+DELETE FROM request_callbacks c USING request_callbacks d
+    WHERE c.request_id = d.request_id AND c.request_status = d.request_status AND c.id > d.id;
+ALTER TABLE request_callbacks ADD CONSTRAINT request_callbacks_request_id_request_status_key
+    UNIQUE (request_id, request_status);
@@ -0,0 +1,4 @@
+-- a source is notified of every new summary of a status, e.g. the import summary of an async job
+ALTER TABLE request_callbacks DROP CONSTRAINT IF EXISTS request_callbacks_request_id_request_status_key;
+CREATE UNIQUE INDEX IF NOT EXISTS request_callbacks_request_status_summary
+    ON request_callbacks(request_id, request_status, md5(summary));
@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS replay_request_cc_servers(bigint, integer[]);
+DROP FUNCTION IF EXISTS undelivered_cc_servers(integer[], jsonb);
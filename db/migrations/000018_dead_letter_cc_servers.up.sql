@@ -0,0 +1,17 @@
+-- cc servers a request was not delivered to, whether they are still retried or failed permanently
+CREATE OR REPLACE FUNCTION undelivered_cc_servers(servers integer[], servers_status jsonb) RETURNS integer[] AS
+$delim$
+    SELECT COALESCE(array_agg(s), '{}'::int[]) FROM unnest(servers) AS s
+    WHERE COALESCE(servers_status->(s::text)->>'status', '') <> 'completed';
+$delim$ LANGUAGE sql STABLE;
+
+-- hands the cc servers of a request back to the retries as if they had not been tried
+CREATE OR REPLACE FUNCTION replay_request_cc_servers(reqId bigint, servers integer[]) RETURNS void AS
+$delim$
+    UPDATE requests SET cc_servers_status = COALESCE(cc_servers_status, '{}'::jsonb) || (
+        SELECT COALESCE(jsonb_object_agg(s::text, COALESCE(cc_servers_status->(s::text), '{}'::jsonb) ||
+            '{"status": "", "statusCode": "", "errors": "", "retries": 0, "nextAttemptAt": "", "failureClass": ""}'::jsonb),
+            '{}'::jsonb)
+        FROM unnest(servers) AS s)
+    WHERE id = reqId AND array_length(servers, 1) IS NOT NULL;
+$delim$ LANGUAGE sql;
@@ -0,0 +1,11 @@
+-- failed copies to cc urls that are due and not out of retries
+DROP FUNCTION IF EXISTS has_failed_cc_urls(jsonb);
+CREATE OR REPLACE FUNCTION has_failed_cc_urls(servers_status jsonb, max_retries integer) RETURNS boolean AS
+$delim$
+    SELECT EXISTS (
+        SELECT 1 FROM jsonb_each(CASE WHEN jsonb_typeof(servers_status) = 'object' THEN servers_status ELSE '{}'::jsonb END) AS s(key, value)
+        WHERE s.key LIKE 'url:%' AND s.value->>'status' NOT IN ('completed', 'error')
+            AND COALESCE((s.value->>'retries')::integer, 0) <= max_retries
+            AND (COALESCE(s.value->>'nextAttemptAt', '') = '' OR (s.value->>'nextAttemptAt')::timestamptz <= now())
+    );
+$delim$ LANGUAGE sql STABLE;
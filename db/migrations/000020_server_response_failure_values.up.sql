@@ -0,0 +1 @@
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS response_failure_values TEXT[] NOT NULL DEFAULT '{}'; -- values at the response path marking failure, empty for the defaults
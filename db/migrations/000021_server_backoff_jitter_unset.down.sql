@@ -0,0 +1,3 @@
+UPDATE servers SET retry_backoff_jitter = 0.2 WHERE retry_backoff_jitter IS NULL;
+ALTER TABLE servers ALTER COLUMN retry_backoff_jitter SET DEFAULT 0.2;
+ALTER TABLE servers ALTER COLUMN retry_backoff_jitter SET NOT NULL;
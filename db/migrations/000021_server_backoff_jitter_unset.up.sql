@@ -0,0 +1,4 @@
+-- servers without a jitter of their own use the default, so that a jitter of 0 turns it off
+ALTER TABLE servers ALTER COLUMN retry_backoff_jitter DROP NOT NULL;
+ALTER TABLE servers ALTER COLUMN retry_backoff_jitter DROP DEFAULT;
+UPDATE servers SET retry_backoff_jitter = NULL WHERE retry_backoff_jitter = 0; -- saved as 0 when it wasn't set
@@ -0,0 +1,9 @@
+CREATE OR REPLACE FUNCTION replay_request_cc_servers(reqId bigint, servers integer[]) RETURNS void AS
+$delim$
+    UPDATE requests SET cc_servers_status = COALESCE(cc_servers_status, '{}'::jsonb) || (
+        SELECT COALESCE(jsonb_object_agg(s::text, COALESCE(cc_servers_status->(s::text), '{}'::jsonb) ||
+            '{"status": "", "statusCode": "", "errors": "", "retries": 0, "nextAttemptAt": "", "failureClass": ""}'::jsonb),
+            '{}'::jsonb)
+        FROM unnest(servers) AS s)
+    WHERE id = reqId AND array_length(servers, 1) IS NOT NULL;
+$delim$ LANGUAGE sql;
@@ -0,0 +1,16 @@
+-- hands the cc servers of a request and its copies to cc urls that weren't delivered back to the retries
+-- as if they had not been tried
+CREATE OR REPLACE FUNCTION replay_request_cc_servers(reqId bigint, servers integer[]) RETURNS void AS
+$delim$
+    UPDATE requests SET cc_servers_status = (CASE WHEN jsonb_typeof(cc_servers_status) = 'object' THEN cc_servers_status ELSE '{}'::jsonb END) || (
+        SELECT COALESCE(jsonb_object_agg(r.key, COALESCE(cc_servers_status->r.key, '{}'::jsonb) ||
+            '{"status": "", "statusCode": "", "errors": "", "retries": 0, "nextAttemptAt": "", "failureClass": ""}'::jsonb),
+            '{}'::jsonb)
+        FROM (
+            SELECT s::text AS key FROM unnest(servers) AS s
+            UNION
+            SELECT u.key FROM jsonb_each(CASE WHEN jsonb_typeof(cc_servers_status) = 'object' THEN cc_servers_status ELSE '{}'::jsonb END) AS u(key, value)
+            WHERE u.key LIKE 'url:%' AND COALESCE(u.value->>'status', '') <> 'completed'
+        ) AS r)
+    WHERE id = reqId;
+$delim$ LANGUAGE sql;
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antchfx/xmlquery"
+	"github.com/tidwall/gjson"
+	"go-dispatcher2/models"
+	"go-dispatcher2/utils/dbutils"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DestinationResult is the outcome of a request as interpreted by the destination's adapter
+type DestinationResult struct {
+	Completed bool   // whether the destination accepted the request
+	Summary   string // short summary of what the destination did with the request
+}
+
+// DestinationAdapter builds the requests sent to a type of destination server
+// and interprets the responses it sends back
+type DestinationAdapter interface {
+	// BuildRequest returns the HTTP request that delivers r to destination.
+	// The destination's credentials are added as the request is sent
+	BuildRequest(r *RequestObject, destination models.Server) (*http.Request, error)
+	// InterpretResponse returns the outcome of a request given the destination's response.
+	// An error is returned if the response cannot be understood at all.
+	InterpretResponse(statusCode int, body []byte) (DestinationResult, error)
+}
+
+// destinationAdapters are the adapters for the known system types, keyed by lower case system type
+var destinationAdapters = map[string]DestinationAdapter{
+	"dhis2": DHIS2Adapter{},
+}
+
+// GetDestinationAdapter returns the adapter for a server's system type.
+// Servers of unknown or no system type use the GenericHTTPAdapter
+func GetDestinationAdapter(systemType string) DestinationAdapter {
+	if adapter, ok := destinationAdapters[strings.ToLower(strings.TrimSpace(systemType))]; ok {
+		return adapter
+	}
+	return GenericHTTPAdapter{}
+}
+
+// GenericHTTPAdapter forwards requests to any HTTP endpoint and treats every 2xx response as success
+type GenericHTTPAdapter struct{}
+
+// BuildRequest ...
+func (a GenericHTTPAdapter) BuildRequest(r *RequestObject, destination models.Server) (*http.Request, error) {
+	return newDestinationRequest(r, destination)
+}
+
+// InterpretResponse ...
+func (a GenericHTTPAdapter) InterpretResponse(statusCode int, body []byte) (DestinationResult, error) {
+	if statusCode/100 == 2 {
+		return DestinationResult{Completed: true, Summary: fmt.Sprintf("Accepted with status %d", statusCode)}, nil
+	}
+	return DestinationResult{Summary: fmt.Sprintf("Rejected with status %d", statusCode)}, nil
+}
+
+// DHIS2Adapter forwards requests to DHIS2 and reads its import summaries
+type DHIS2Adapter struct{}
+
+// BuildRequest ...
+func (a DHIS2Adapter) BuildRequest(r *RequestObject, destination models.Server) (*http.Request, error) {
+	return newDestinationRequest(r, destination)
+}
+
+// InterpretResponse ...
+func (a DHIS2Adapter) InterpretResponse(statusCode int, body []byte) (DestinationResult, error) {
+	result := models.ImportSummary{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return DestinationResult{}, fmt.Errorf("failed to decode import summary: %w", err)
+	}
+	if statusCode/100 == 2 {
+		return DestinationResult{
+			Completed: true,
+			Summary: fmt.Sprintf("Created: %d, Updated: %d",
+				result.Response.Stats.Created, result.Response.Stats.Updated),
+		}, nil
+	}
+	return DestinationResult{Summary: "request might have conflicts"}, nil
+}
+
+// defaultFailureValues are the values extracted by a server's response path that mark a request as failed when
+// the server doesn't list its own. Empty values and "0" are not among them as paths often pick counts or
+// fields that are only filled in on errors
+var defaultFailureValues = []string{"false", "error", "errors", "failed", "failure", "rejected"}
+
+// isFailureValue returns whether the value extracted by the server's response path marks a request as failed.
+// Values are compared ignoring case and surrounding white space
+func isFailureValue(destination models.Server, value string) bool {
+	failureValues := destination.ResponseFailureValues()
+	if len(failureValues) == 0 {
+		failureValues = defaultFailureValues
+	}
+	value = strings.TrimSpace(value)
+	for _, failureValue := range failureValues {
+		if strings.EqualFold(value, strings.TrimSpace(failureValue)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InterpretDestinationResponse judges the response of a destination server to a request.
+// Servers that don't parse responses accept any 2xx response. Otherwise the value at the server's
+// JSON or XML response path decides the outcome when a path is configured for the response's
+// format, failing the request when it is one of the server's failure values, and the adapter of the server's
+// system type decides when it is not.
+func InterpretDestinationResponse(
+	adapter DestinationAdapter, destination models.Server, statusCode int, contentType string, body []byte,
+) (DestinationResult, error) {
+	if !destination.ParseResponses() {
+		return GenericHTTPAdapter{}.InterpretResponse(statusCode, body)
+	}
+	var value string
+	var err error
+	switch {
+	case isXMLResponse(contentType, body) && len(destination.XMLResponseXPATH()) > 0:
+		value, err = xmlResponseValue(body, destination.XMLResponseXPATH())
+	case !isXMLResponse(contentType, body) && len(destination.JSONResponseXPATH()) > 0:
+		value, err = jsonResponseValue(body, destination.JSONResponseXPATH())
+	default:
+		return adapter.InterpretResponse(statusCode, body)
+	}
+	if err != nil {
+		return DestinationResult{}, err
+	}
+	return DestinationResult{
+		Completed: statusCode/100 == 2 && !isFailureValue(destination, value),
+		Summary:   value,
+	}, nil
+}
+
+// isXMLResponse reports whether a response is XML going by its content type or else its body
+func isXMLResponse(contentType string, body []byte) bool {
+	if len(contentType) > 0 {
+		return strings.Contains(strings.ToLower(contentType), "xml")
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+}
+
+// jsonResponseValue returns the value at the gjson path in a JSON response
+func jsonResponseValue(body []byte, path string) (string, error) {
+	if !gjson.ValidBytes(body) {
+		return "", errors.New("failed to decode JSON response")
+	}
+	result := gjson.GetBytes(body, path)
+	if !result.Exists() {
+		return "", fmt.Errorf("no value at '%s' in JSON response", path)
+	}
+	return result.String(), nil
+}
+
+// xmlResponseValue returns the text at the XPath in an XML response
+func xmlResponseValue(body []byte, path string) (string, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode XML response: %w", err)
+	}
+	node, err := xmlquery.Query(doc, path)
+	if err != nil {
+		return "", fmt.Errorf("invalid XPath '%s': %w", path, err)
+	}
+	if node == nil {
+		return "", fmt.Errorf("no value at '%s' in XML response", path)
+	}
+	return node.InnerText(), nil
+}
+
+// newDestinationRequest builds the request carrying r to the destination server's URL
+func newDestinationRequest(r *RequestObject, destination models.Server) (*http.Request, error) {
+	destURL := destination.URL()
+	if len(r.URLSurffix) > 1 {
+		destURL += r.URLSurffix
+	}
+	return newRequest(r, destination.HTTPMethod(), destURL, destination.URLParams())
+}
+
+// newRequest builds the request carrying r's body verbatim to destURL with urlParams.
+// Requests whose body is used as query parameters are sent with an empty body instead
+func newRequest(r *RequestObject, method, destURL string, urlParams dbutils.MapAnything) (*http.Request, error) {
+	if !r.BodyIsQueryParams {
+		req, err := http.NewRequest(method, AddParamsToURL(destURL, urlParams), strings.NewReader(r.Body))
+		if err != nil {
+			return nil, err
+		}
+		contentType := r.ContentType
+		if len(contentType) == 0 { // requests queued before content types were kept
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	}
+
+	params, err := r.bodyQueryParams()
+	if err != nil {
+		return nil, err
+	}
+	// the server's own parameters take precedence over those from the body
+	for k, v := range urlParams {
+		params.Set(k, fmt.Sprintf("%v", v))
+	}
+	if !strings.EqualFold(method, http.MethodGet) {
+		method = http.MethodPost
+	}
+	if !strings.HasSuffix(destURL, "?") {
+		destURL += "?"
+	}
+	return http.NewRequest(method, destURL+params.Encode(), nil)
+}
+
+// bodyQueryParams flattens the request body into query parameters. Form encoded bodies are used as they are
+// while the fields of JSON objects become parameters, nested fields named by their path, e.g. "contact.name",
+// and arrays repeating their parameter
+func (r *RequestObject) bodyQueryParams() (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.ContentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		return url.ParseQuery(r.Body)
+	}
+	params := url.Values{}
+	if len(strings.TrimSpace(r.Body)) == 0 {
+		return params, nil
+	}
+	var body interface{}
+	decoder := json.NewDecoder(strings.NewReader(r.Body))
+	decoder.UseNumber() // keep numbers as they were sent
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to read request body as query parameters: %w", err)
+	}
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("only a JSON object body can be used as query parameters")
+	}
+	for k, v := range fields {
+		addQueryParam(params, k, v)
+	}
+	return params, nil
+}
+
+// addQueryParam adds value to params under key flattening objects and arrays
+func addQueryParam(params url.Values, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			addQueryParam(params, key+"."+k, field)
+		}
+	case []interface{}:
+		for _, item := range v {
+			addQueryParam(params, key, item)
+		}
+	case nil:
+		params.Add(key, "")
+	default:
+		params.Add(key, fmt.Sprintf("%v", v))
+	}
+}
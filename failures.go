@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidRequest is returned for requests that can't be sent as they are
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrAuthorization is returned when a request can't be given the credentials of its destination
+var ErrAuthorization = errors.New("failed to authenticate")
+
+// FailureClass is the class of a failed attempt at sending a request to a server
+type FailureClass string
+
+// constants for the classes of failures
+const (
+	FailureNetwork     = FailureClass("network")      // the server could not be reached
+	FailureTimeout     = FailureClass("timeout")      // the server took too long to respond
+	FailureAuth        = FailureClass("auth")         // the server rejected our credentials
+	FailureValidation  = FailureClass("validation")   // the server rejected the request itself, e.g. conflicts
+	FailureRateLimited = FailureClass("rate_limited") // the server asked us to slow down
+	FailureServer      = FailureClass("server")       // the server failed to handle the request
+	FailurePermanent   = FailureClass("permanent")    // the request can never be sent, e.g. it has no body
+)
+
+// Retryable returns whether requests failing with the class of failure are retried.
+// Failures that would recur on every retry, like bad credentials or invalid requests, are permanent.
+func (f FailureClass) Retryable() bool {
+	switch f {
+	case FailureAuth, FailureValidation, FailurePermanent:
+		return false
+	default:
+		return true
+	}
+}
+
+// ClassifyError returns the class of an error sending a request
+func ClassifyError(err error) FailureClass {
+	if errors.Is(err, ErrInvalidRequest) {
+		return FailureValidation
+	}
+	if errors.Is(err, ErrAuthorization) {
+		return FailureAuth
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailureTimeout
+	}
+	return FailureNetwork
+}
+
+// ClassifyStatus returns the class of failure of a response with a non 2xx status code
+func ClassifyStatus(statusCode int) FailureClass {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+		statusCode == http.StatusProxyAuthRequired:
+		return FailureAuth
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return FailureTimeout
+	case statusCode == http.StatusTooManyRequests:
+		return FailureRateLimited
+	case statusCode >= 500:
+		return FailureServer
+	case statusCode >= 400:
+		return FailureValidation
+	default: // a response we don't understand, likely a misbehaving server
+		return FailureServer
+	}
+}
+
+// RetryAfter returns the wait asked for by a response's Retry-After header, given in seconds or as a date
+func RetryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Failure is a failed attempt at sending a request to a server
+type Failure struct {
+	Class      FailureClass
+	StatusCode string
+	Errors     string
+	Response   string
+	RetryAfter time.Duration // the least wait before a retry as asked for by the server
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/robfig/cron/v3"
+	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -42,73 +47,116 @@ func main() {
 	}
 	LoadServersFromConfigFiles(config.ServersConfigMap)
 
+	// ctx is done on SIGINT or SIGTERM after which nothing new is started. Requests in flight are given until
+	// the drain deadline, when drainCtx is done, to finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	drainCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
 	go func() {
-		// retrying incomplete requests runs every 5 minutes
-		log.WithFields(log.Fields{"RetryCronExpression": config.Dispatcher2Conf.Server.RetryCronExpression}).Info(
-			"Request Retry Cron Expression")
-		// Create a new scheduler
-		c := cron.New()
-		_, err := c.AddFunc(config.Dispatcher2Conf.Server.RetryCronExpression, func() {
-			RetryIncompleteRequests()
-		})
-		if err != nil {
-			log.WithError(err).Error("Error scheduling incomplete request retry task:")
-		}
-		c.Start()
-
+		<-ctx.Done()
+		drainTimeout := time.Duration(config.Dispatcher2Conf.Server.ShutdownDrainTimeout) * time.Second
+		log.WithField("drainTimeout", drainTimeout).Info("Shutting down, draining requests in flight")
+		time.AfterFunc(drainTimeout, abandon)
 	}()
-	/*Do proxy Stuff Here */
-	go func() {
-		proxyRouter := gin.Default()
-		proxyRouter.Use(controllers.APIMiddleware(dbConn))
-		proxyRouter.Any("/*proxyPath", controllers.Proxy)
 
-		_ = proxyRouter.Run(":" + config.Dispatcher2Conf.Server.ProxyPort)
+	// retrying incomplete requests runs every 5 minutes
+	log.WithFields(log.Fields{"RetryCronExpression": config.Dispatcher2Conf.Server.RetryCronExpression}).Info(
+		"Request Retry Cron Expression")
+	// Create a new scheduler
+	c := cron.New()
+	_, err = c.AddFunc(config.Dispatcher2Conf.Server.RetryCronExpression, func() {
+		RetryIncompleteRequests(ctx, drainCtx)
+	})
+	if err != nil {
+		log.WithError(err).Error("Error scheduling incomplete request retry task:")
+	}
+	// callbacks to source servers are delivered every minute, each retried with its own backoff
+	_, err = c.AddFunc("@every 1m", func() {
+		models.DeliverDueCallbacks(ctx, drainCtx, dbConn)
+	})
+	if err != nil {
+		log.WithError(err).Error("Error scheduling request callback delivery task:")
+	}
+	c.Start()
+	// no run is started once shutting down, while runs under way stop taking new work and finish what they sent
+	cronStopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		<-c.Stop().Done()
+		close(cronStopped)
 	}()
 
-	jobs := make(chan int)
 	var wg sync.WaitGroup
-
-	seenMap := make(map[models.RequestID]bool)
-	mutex := &sync.Mutex{}
-	rWMutex := &sync.RWMutex{}
+	/*Do proxy Stuff Here */
+	proxyRouter := gin.Default()
+	proxyRouter.Use(controllers.APIMiddleware(dbConn))
+	proxyRouter.Any("/*proxyPath", controllers.Proxy)
+	wg.Add(1)
+	go serveHTTP(ctx, &wg, "proxy", ":"+config.Dispatcher2Conf.Server.ProxyPort, proxyRouter)
 
 	if !*config.SkipRequestProcessing {
 		// don't produce anything if skip processing is enabled
+		jobs := make(chan int, config.Dispatcher2Conf.Server.MaxConcurrent)
 
 		// Start the producer goroutine
 		wg.Add(1)
-		go Produce(dbConn, jobs, &wg, mutex, seenMap)
+		go Produce(ctx, dbConn, jobs, &wg)
 
 		// Start the consumer goroutine
 		wg.Add(1)
-		go StartConsumers(jobs, &wg, rWMutex, seenMap)
+		go StartConsumers(ctx, drainCtx, jobs, &wg)
 	}
-	scheduledJobs := make(chan int64)
-	workingOn := make(map[int64]bool)
-	var workingOnMutex = &sync.Mutex{}
-	var rWworkingOnMutex = &sync.RWMutex{}
 
 	if !*config.SkipScheduleProcessing {
-		wg.Add(1)
-		go ProduceSchedules(dbConn, scheduledJobs, &wg, workingOnMutex, workingOn)
+		scheduledJobs := make(chan int64)
+		workingOn := make(map[int64]bool)
+		var workingOnMutex = &sync.RWMutex{}
 
 		wg.Add(1)
-		go StartScheduleConsumers(scheduledJobs, &wg, rWworkingOnMutex, workingOn)
+		go ProduceSchedules(ctx, dbConn, scheduledJobs, &wg, workingOnMutex, workingOn)
 
+		StartScheduleConsumers(ctx, drainCtx, scheduledJobs, &wg, workingOnMutex, workingOn)
 	}
 
 	// Start the backend API gin server
 	wg.Add(1)
-	go startAPIServer(&wg)
+	go serveHTTP(ctx, &wg, "API", ":"+config.Dispatcher2Conf.Server.Port, apiRouter())
 
+	<-ctx.Done()
+	<-cronStopped
 	wg.Wait()
-	close(scheduledJobs)
-	close(jobs)
+	// whatever is still claimed was cut short by the drain deadline
+	releaseInstanceClaims(dbConn)
+	_ = dbConn.Close()
+	log.Info("Shut down")
 }
 
-func startAPIServer(wg *sync.WaitGroup) {
+// serveHTTP serves handler on addr until ctx is done, then stops taking requests and waits for those
+// being served until the drain deadline
+func serveHTTP(ctx context.Context, wg *sync.WaitGroup, name, addr string, handler http.Handler) {
 	defer wg.Done()
+	srv := &http.Server{Addr: addr, Handler: handler}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(),
+			time.Duration(config.Dispatcher2Conf.Server.ShutdownDrainTimeout)*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).WithField("server", name).Error("Failed to shut down HTTP server gracefully")
+		}
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).WithField("server", name).Error("HTTP server failed")
+		return
+	}
+	<-stopped
+}
+
+// apiRouter returns the router of the backend API
+func apiRouter() *gin.Engine {
 	router := gin.Default()
 	v2 := router.Group("/api", models.BasicAuth())
 	{
@@ -125,23 +173,49 @@ func startAPIServer(wg *sync.WaitGroup) {
 		v2.POST("/queue", q.Queue)
 		v2.GET("/queue", q.Requests)
 		v2.GET("/queue/:id", q.GetRequest)
+		v2.POST("/queue/:id/replay", q.ReplayRequest)
+		v2.GET("/deadletters", q.DeadLetters)
+		v2.POST("/deadletters/replay", q.ReplayDeadLetters)
 		v2.DELETE("/queue/:id", q.DeleteRequest)
 
+		rp := new(controllers.RapidProController)
+		v2.POST("/rp-queue", rp.RapidProQueue)
+		sms := new(controllers.SMSController)
+		v2.POST("/sms-queue", sms.SMSQueue)
+
+		im := new(controllers.IndicatorMappingController)
+		v2.GET("/indicatorMappings", im.ListIndicatorMappings)
+		v2.POST("/indicatorMappings", im.NewIndicatorMapping)
+		v2.GET("/indicatorMappings/:id", im.GetIndicatorMapping)
+		v2.PUT("/indicatorMappings/:id", im.UpdateIndicatorMapping)
+		v2.DELETE("/indicatorMappings/:id", im.DeleteIndicatorMapping)
+
 		//s := new(controllers.ServerController)
 		//v2.POST("/servers", s.CreateServer)
 		//v2.POST("/importServers", s.ImportServers)
+		sv := new(controllers.ServerController)
+		v2.GET("/circuitbreakers", sv.CircuitBreakers)
 		s := new(controllers.ScheduleController)
 		v2.GET("/schedules", s.ListSchedules)
 		v2.POST("/schedules", s.NewSchedule)
 		v2.GET("/schedules/:id", s.GetSchedule)
+		v2.GET("/schedules/:id/runs", s.ListScheduleRuns)
 		v2.POST("/schedules/:id", s.UpdateSchedule)
 		v2.DELETE("/schedules/:id", s.DeleteSchedule)
 
+		rt := new(controllers.RouteController)
+		v2.GET("/routes", rt.ListRoutes)
+		v2.POST("/routes", rt.NewRoute)
+		v2.GET("/routes/:id", rt.GetRoute)
+		v2.POST("/routes/:id", rt.UpdateRoute)
+		v2.DELETE("/routes/:id", rt.DeleteRoute)
+		v2.POST("/routes/:id/dryrun", rt.DryRun)
+
 	}
 	// Handle error response when a route is not defined
 	router.NoRoute(func(c *gin.Context) {
 		c.String(404, "Page Not Found!")
 	})
 
-	_ = router.Run(":" + fmt.Sprintf("%s", config.Dispatcher2Conf.Server.Port))
+	return router
 }
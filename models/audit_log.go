@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// constants for the types of audit log entries
+const (
+	AuditLogRequests = "requests"
+)
+
+const addAuditLogSQL = `INSERT INTO audit_log (logtype, actor, action, remote_ip, detail, created_by)
+	SELECT $1, username, $2, NULLIF($3, '')::inet, $4, id FROM users WHERE id = $5`
+
+// AddAuditLog records that the user with userID performed action from remoteIP. The detail is kept as JSON.
+// It fails if the user doesn't exist so that no action goes unrecorded
+func AddAuditLog(tx *sqlx.Tx, logType, action string, userID int64, remoteIP string, detail interface{}) error {
+	detailJSON, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(addAuditLogSQL, logType, action, remoteIP, string(detailJSON), userID)
+	if err == nil {
+		var added int64
+		if added, err = result.RowsAffected(); err == nil && added == 0 {
+			err = fmt.Errorf("no user with id %d to record %s by", userID, action)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"action": action, "userID": userID}).Error("Failed to add audit log")
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// constants for the methods used to authenticate to servers
+const (
+	AuthMethodBasic  = "Basic"  // username and password
+	AuthMethodToken  = "Token"  // DHIS2 personal access token sent as "ApiToken <token>"
+	AuthMethodBearer = "Bearer" // AuthToken sent as a bearer token
+	AuthMethodOAuth2 = "OAuth2" // bearer tokens got with the OAuth2 client credentials grant
+	AuthMethodAPIKey = "APIKey" // AuthToken sent in the APIKeyHeader
+	AuthMethodHMAC   = "HMAC"   // requests signed with the AuthToken
+)
+
+// default headers of the API key and HMAC methods
+const (
+	defaultAPIKeyHeader = "X-API-Key"
+	defaultHMACHeader   = "X-Dispatcher2-Signature"
+	hmacTimestampHeader = "X-Dispatcher2-Timestamp"
+)
+
+// AuthProvider authenticates the requests sent to a server
+type AuthProvider interface {
+	// Authorize adds the server's credentials to req
+	Authorize(req *http.Request) error
+	// Unauthorized tells the provider the server rejected the credentials added to req,
+	// so that credentials it caches are not used again
+	Unauthorized(req *http.Request)
+}
+
+// AuthProvider returns the provider authenticating requests to the server with its auth method.
+// Servers with no known auth method use Basic authentication
+func (s *Server) AuthProvider() AuthProvider {
+	switch s.s.AuthMethod {
+	case AuthMethodToken:
+		return headerAuth{header: "Authorization", value: "ApiToken " + s.s.AuthToken}
+	case AuthMethodBearer:
+		return headerAuth{header: "Authorization", value: "Bearer " + s.s.AuthToken}
+	case AuthMethodOAuth2:
+		return &oauth2Auth{server: *s}
+	case AuthMethodAPIKey:
+		header := s.s.APIKeyHeader
+		if len(header) == 0 {
+			header = defaultAPIKeyHeader
+		}
+		return headerAuth{header: header, value: s.s.AuthToken}
+	case AuthMethodHMAC:
+		header := s.s.HMACHeader
+		if len(header) == 0 {
+			header = defaultHMACHeader
+		}
+		return hmacAuth{header: header, key: s.s.AuthToken}
+	default:
+		auth := s.s.Username + ":" + s.s.Password
+		return headerAuth{header: "Authorization", value: "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))}
+	}
+}
+
+// headerAuth sets a header to a fixed value
+type headerAuth struct {
+	header string
+	value  string
+}
+
+// Authorize sets the header
+func (a headerAuth) Authorize(req *http.Request) error {
+	req.Header.Set(a.header, a.value)
+	return nil
+}
+
+// Unauthorized does nothing as fixed credentials aren't cached
+func (a headerAuth) Unauthorized(*http.Request) {}
+
+// hmacAuth signs requests as callbacks are signed, with the timestamp in its own header
+type hmacAuth struct {
+	header string
+	key    string
+}
+
+// Authorize signs the timestamp and body of req
+func (a hmacAuth) Authorize(req *http.Request) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return fmt.Errorf("failed to read request body to sign: %w", err)
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(hmacTimestampHeader, timestamp)
+	req.Header.Set(a.header, SignCallback(a.key, timestamp, body))
+	return nil
+}
+
+// Unauthorized does nothing as signatures aren't cached
+func (a hmacAuth) Unauthorized(*http.Request) {}
+
+// oauth2Token is an access token got from a server's token URL
+type oauth2Token struct {
+	accessToken string
+	expiresAt   time.Time
+}
+
+// oauth2TokenExpiryMargin is how long before it expires a token is refreshed
+const oauth2TokenExpiryMargin = 30 * time.Second
+
+// oauth2TokenKey identifies the credentials a token was got with, so that tokens aren't reused
+// after a server's credentials change
+type oauth2TokenKey struct {
+	tokenURL, clientID, clientSecret, scopes string
+}
+
+// oauth2TokenEntry is the cached token of a set of credentials. Its lock is held while the token is
+// fetched so that requests to a server wait for a single fetch without holding up other servers
+type oauth2TokenEntry struct {
+	mu    sync.Mutex
+	token oauth2Token
+}
+
+var (
+	oauth2Tokens   = make(map[oauth2TokenKey]*oauth2TokenEntry)
+	oauth2TokensMu sync.Mutex
+)
+
+// oauth2Auth sends bearer tokens got with the client credentials grant. Tokens are cached until shortly
+// before they expire or until the server rejects them
+type oauth2Auth struct {
+	server Server
+}
+
+// tokenEntry returns the cache entry of the server's credentials
+func (a *oauth2Auth) tokenEntry() *oauth2TokenEntry {
+	key := oauth2TokenKey{
+		a.server.s.OAuth2TokenURL, a.server.s.OAuth2ClientID, a.server.s.OAuth2ClientSecret, a.server.s.OAuth2Scopes}
+	oauth2TokensMu.Lock()
+	defer oauth2TokensMu.Unlock()
+	entry, ok := oauth2Tokens[key]
+	if !ok {
+		entry = &oauth2TokenEntry{}
+		oauth2Tokens[key] = entry
+	}
+	return entry
+}
+
+// Authorize adds a current access token to req, getting a new one if need be
+func (a *oauth2Auth) Authorize(req *http.Request) error {
+	entry := a.tokenEntry()
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if time.Now().Add(oauth2TokenExpiryMargin).After(entry.token.expiresAt) {
+		token, err := a.fetchToken(req)
+		if err != nil {
+			entry.token = oauth2Token{}
+			return err
+		}
+		entry.token = token
+	}
+	req.Header.Set("Authorization", "Bearer "+entry.token.accessToken)
+	return nil
+}
+
+// Unauthorized drops the cached token if it is the one req was sent with, so that the next request gets a new one
+func (a *oauth2Auth) Unauthorized(req *http.Request) {
+	entry := a.tokenEntry()
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if len(entry.token.accessToken) > 0 && req.Header.Get("Authorization") == "Bearer "+entry.token.accessToken {
+		entry.token = oauth2Token{}
+	}
+}
+
+// TokenError is returned when a server's OAuth2 token URL answers with a status other than 200
+type TokenError struct {
+	StatusCode int
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("failed to get OAuth2 token: token URL returned status %d", e.StatusCode)
+}
+
+// fetchToken gets an access token from the server's token URL within req's context
+func (a *oauth2Auth) fetchToken(req *http.Request) (oauth2Token, error) {
+	if len(a.server.s.OAuth2TokenURL) == 0 {
+		return oauth2Token{}, errors.New("server has no OAuth2 token URL")
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.server.s.OAuth2Scopes) > 0 {
+		form.Set("scope", a.server.s.OAuth2Scopes)
+	}
+	tokenReq, err := http.NewRequestWithContext(
+		req.Context(), http.MethodPost, a.server.s.OAuth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenReq.SetBasicAuth(url.QueryEscape(a.server.s.OAuth2ClientID), url.QueryEscape(a.server.s.OAuth2ClientSecret))
+
+	client, err := a.server.HTTPClient()
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	resp, err := client.Do(tokenReq)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("failed to get OAuth2 token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return oauth2Token{}, &TokenError{StatusCode: resp.StatusCode}
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return oauth2Token{}, fmt.Errorf("failed to decode OAuth2 token: %w", err)
+	}
+	if len(body.AccessToken) == 0 {
+		return oauth2Token{}, errors.New("token URL returned no OAuth2 access token")
+	}
+	expiresIn := time.Duration(body.ExpiresIn) * time.Second
+	if expiresIn <= 0 { // tokens without an expiry are still refreshed now and then
+		expiresIn = time.Hour
+	}
+	return oauth2Token{accessToken: body.AccessToken, expiresAt: time.Now().Add(expiresIn)}, nil
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ExponentialBackoff returns the wait before the next attempt after the given number of failed attempts.
+// The wait doubles from base with every failed attempt up to max, and up to the jitter fraction of it is
+// taken off at random so that retries of requests that failed together are spread out.
+func ExponentialBackoff(base, max time.Duration, jitter float64, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	if jitter > 0 {
+		backoff -= time.Duration(jitter * rand.Float64() * float64(backoff))
+	}
+	return backoff
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/config"
+	"strconv"
+	"time"
+)
+
+// constants for the status of a callback
+const (
+	CallbackStatusPending = "pending"
+	CallbackStatusSent    = "sent"
+	CallbackStatusFailed  = "failed"
+)
+
+// maxCallbackBackoff caps the wait between attempts to deliver a callback
+const maxCallbackBackoff = 6 * time.Hour
+
+// RequestCallback is a notification to a request's source server that the request reached a final status
+type RequestCallback struct {
+	ID            int64         `db:"id" json:"id"`
+	RequestID     RequestID     `db:"request_id" json:"requestID"`
+	ServerID      int64         `db:"server_id" json:"serverID"`
+	URL           string        `db:"url" json:"url"`
+	RequestStatus RequestStatus `db:"request_status" json:"requestStatus"`
+	Summary       string        `db:"summary" json:"summary"`
+	Status        string        `db:"status" json:"status"`
+	Attempts      int           `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time     `db:"next_attempt_at" json:"nextAttemptAt"`
+	StatusCode    string        `db:"statuscode" json:"statusCode"`
+	Errors        string        `db:"errors" json:"errors"`
+	Created       time.Time     `db:"created" json:"created"`
+	Updated       time.Time     `db:"updated" json:"updated"`
+	UID           string        `db:"uid" json:"uid"`
+	SubmissionID  string        `db:"submissionid" json:"submissionId"`
+}
+
+// CallbackPayload is the JSON notification posted to the source server's callback URL
+type CallbackPayload struct {
+	UID          string        `json:"uid"`
+	SubmissionID string        `json:"submissionId"`
+	Status       RequestStatus `json:"status"`
+	Summary      string        `json:"summary"`
+}
+
+const queueCallbackSQL = `INSERT INTO
+	request_callbacks (request_id, server_id, url, request_status, summary)
+	VALUES ($1, $2, $3, $4, $5) ON CONFLICT (request_id, request_status, md5(summary)) DO NOTHING`
+
+// QueueRequestCallback queues the notification of the source server that the request reached status.
+// Nothing is queued for statuses that are not final or sources that don't allow callbacks, and a source is
+// only notified once of each status with the same summary.
+func QueueRequestCallback(tx *sqlx.Tx, requestID RequestID, source int, status RequestStatus, summary string) error {
+	switch status {
+	case RequestStatusCompleted, RequestStatusFailed, RequestStatusError, RequestStatusExpired:
+	default:
+		return nil
+	}
+	server, ok := ServerMap[strconv.Itoa(source)]
+	if !ok || !server.AllowCallbacks() || len(server.CallbackURL()) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(queueCallbackSQL, requestID, server.ID(), server.CallbackURL(), status, summary)
+	if err != nil {
+		log.WithError(err).WithField("requestID", requestID).Error("Failed to queue request callback")
+	}
+	return err
+}
+
+// callbackClaimDuration is how long callbacks claimed for delivery are kept from other instances.
+// Callbacks left undelivered, e.g. by an instance that stopped, are tried again once it is over
+const callbackClaimDuration = 15 * time.Minute
+
+const claimDueCallbacksSQL = `
+WITH due AS (
+	SELECT id FROM request_callbacks WHERE status = 'pending' AND next_attempt_at <= NOW()
+	ORDER BY next_attempt_at LIMIT 100 FOR UPDATE SKIP LOCKED)
+UPDATE request_callbacks c SET next_attempt_at = NOW() + $1 * INTERVAL '1 second'
+FROM due, requests r WHERE c.id = due.id AND r.id = c.request_id
+RETURNING c.*, r.uid, r.submissionid`
+
+const updateCallbackSQL = `UPDATE request_callbacks SET
+	(status, attempts, next_attempt_at, statuscode, errors, updated) =
+	(:status, :attempts, :next_attempt_at, :statuscode, :errors, current_timestamp) WHERE id = :id`
+
+// DeliverDueCallbacks makes the next attempt at delivering each of the pending callbacks that are due.
+// The callbacks are claimed before they are posted so that no transaction is held open while waiting on
+// source servers, and the outcome of each is recorded as soon as it is known. No callback is started once ctx
+// is done, handing those not yet delivered back to be delivered without waiting for their claim to run out,
+// while those being posted are given until drainCtx is done
+func DeliverDueCallbacks(ctx, drainCtx context.Context, db *sqlx.DB) {
+	if ctx.Err() != nil {
+		return
+	}
+	var callbacks []RequestCallback
+	err := db.Select(&callbacks, claimDueCallbacksSQL, int(callbackClaimDuration.Seconds()))
+	if err != nil {
+		log.WithError(err).Error("Failed to claim due callbacks")
+		return
+	}
+	for i := range callbacks {
+		callback := &callbacks[i]
+		if ctx.Err() != nil {
+			ids := make([]int64, 0, len(callbacks)-i)
+			for _, c := range callbacks[i:] {
+				ids = append(ids, c.ID)
+			}
+			_, err := db.Exec(`UPDATE request_callbacks SET next_attempt_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+			if err != nil {
+				log.WithError(err).Error("Failed to release undelivered callbacks")
+			}
+			return
+		}
+		callback.Deliver(drainCtx)
+		if _, err := db.NamedExec(updateCallbackSQL, callback); err != nil {
+			log.WithError(err).WithField("callbackID", callback.ID).Error("Failed to update callback")
+		}
+	}
+}
+
+// Deliver posts the callback to the source server once and sets its status and next attempt.
+// Failed attempts are retried with exponential backoff until the configured attempts are used up.
+func (c *RequestCallback) Deliver(ctx context.Context) {
+	c.Attempts += 1
+	payload, _ := json.Marshal(CallbackPayload{
+		UID: c.UID, SubmissionID: c.SubmissionID, Status: c.RequestStatus, Summary: c.Summary})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var resp *resty.Response
+	httpClient, err := DefaultHTTPClient()
+	if err == nil {
+		resp, err = resty.NewWithClient(httpClient).R().
+			SetContext(ctx).
+			SetHeader("Content-Type", "application/json").
+			SetHeader("X-Dispatcher2-Timestamp", timestamp).
+			SetHeader("X-Dispatcher2-Signature", SignCallback(config.Dispatcher2Conf.Server.CallbackSigningKey, timestamp, payload)).
+			SetBody(payload).
+			Post(c.URL)
+	}
+	switch {
+	case err != nil:
+		c.StatusCode = "ERROR02"
+		c.Errors = err.Error()
+	case resp.IsSuccess():
+		c.Status = CallbackStatusSent
+		c.StatusCode = strconv.Itoa(resp.StatusCode())
+		c.Errors = ""
+		log.WithFields(log.Fields{"requestID": c.RequestID, "url": c.URL}).Info("Request callback sent")
+		return
+	default:
+		c.StatusCode = strconv.Itoa(resp.StatusCode())
+		c.Errors = fmt.Sprintf("callback rejected with status %d", resp.StatusCode())
+	}
+	log.WithFields(log.Fields{
+		"requestID": c.RequestID, "url": c.URL, "attempts": c.Attempts, "error": c.Errors,
+	}).Warn("Failed to deliver request callback")
+	if c.Attempts >= config.Dispatcher2Conf.Server.CallbackMaxAttempts {
+		c.Status = CallbackStatusFailed
+		return
+	}
+	c.NextAttemptAt = time.Now().Add(CallbackBackoff(config.Dispatcher2Conf.Server.CallbackRetryInterval, c.Attempts))
+}
+
+// CallbackBackoff returns the wait after the given number of failed attempts to deliver a callback,
+// doubling from interval seconds and capped at maxCallbackBackoff
+func CallbackBackoff(interval, attempts int) time.Duration {
+	if interval <= 0 {
+		interval = 60
+	}
+	return ExponentialBackoff(time.Duration(interval)*time.Second, maxCallbackBackoff, 0, attempts)
+}
+
+// SignCallback returns the signature of a callback sent at timestamp. The signature is "sha256=" followed by
+// the hex encoded HMAC-SHA256, keyed with the signing key, of the timestamp, a dot and the payload.
+func SignCallback(key, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
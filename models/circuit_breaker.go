@@ -0,0 +1,164 @@
+package models
+
+import (
+	"go-dispatcher2/config"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a server's circuit breaker
+type BreakerState string
+
+// constants for the states of a circuit breaker
+const (
+	BreakerClosed   = BreakerState("closed")    // requests are sent to the server
+	BreakerOpen     = BreakerState("open")      // requests to the server are held back until the cool-down ends
+	BreakerHalfOpen = BreakerState("half_open") // a single trial request is let through to test the server
+)
+
+// CircuitBreaker stops requests to a server after consecutive failures so that consumers don't wait on
+// a server that is down. Once open, requests are held back for the cool-down after which a single trial
+// request decides whether the breaker closes again or stays open for another cool-down.
+type CircuitBreaker struct {
+	mu                  sync.Mutex
+	serverID            ServerID
+	state               BreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	trialStarted        time.Time
+}
+
+// CircuitBreakerStatus is the state of a server's circuit breaker as reported by the API
+type CircuitBreakerStatus struct {
+	ServerID            ServerID     `json:"serverID"`
+	ServerName          string       `json:"serverName"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+	RetryAt             *time.Time   `json:"retryAt,omitempty"`
+}
+
+var (
+	circuitBreakers   = make(map[ServerID]*CircuitBreaker)
+	circuitBreakersMu sync.Mutex
+)
+
+// GetCircuitBreaker returns the circuit breaker of the server with serverID
+func GetCircuitBreaker(serverID ServerID) *CircuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+	breaker, ok := circuitBreakers[serverID]
+	if !ok {
+		breaker = &CircuitBreaker{serverID: serverID, state: BreakerClosed}
+		circuitBreakers[serverID] = breaker
+	}
+	return breaker
+}
+
+// CircuitBreakerStatuses returns the state of the circuit breakers of all servers that have been sent requests
+func CircuitBreakerStatuses() []CircuitBreakerStatus {
+	circuitBreakersMu.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(circuitBreakers))
+	for _, breaker := range circuitBreakers {
+		breakers = append(breakers, breaker)
+	}
+	circuitBreakersMu.Unlock()
+
+	statuses := make([]CircuitBreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ServerID < statuses[j].ServerID })
+	return statuses
+}
+
+// breakerThreshold is the consecutive failures that open a circuit breaker
+func breakerThreshold() int {
+	if config.Dispatcher2Conf.Server.CircuitBreakerThreshold <= 0 {
+		return 5
+	}
+	return config.Dispatcher2Conf.Server.CircuitBreakerThreshold
+}
+
+// breakerCooldown is how long an open circuit breaker holds back requests
+func breakerCooldown() time.Duration {
+	if config.Dispatcher2Conf.Server.CircuitBreakerCooldown <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(config.Dispatcher2Conf.Server.CircuitBreakerCooldown) * time.Second
+}
+
+// Allow returns whether a request may be sent to the server. When it may not, the time after which
+// it may be tried again is returned.
+func (b *CircuitBreaker) Allow() (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(breakerCooldown())
+		if now.Before(retryAt) {
+			return false, retryAt
+		}
+		b.state = BreakerHalfOpen
+		b.trialStarted = now
+		return true, time.Time{}
+	case BreakerHalfOpen:
+		// a trial that never reported back doesn't hold the breaker half open forever
+		if retryAt := b.trialStarted.Add(breakerCooldown()); now.Before(retryAt) {
+			return false, retryAt
+		}
+		b.trialStarted = now
+		return true, time.Time{}
+	default:
+		return true, time.Time{}
+	}
+}
+
+// Success records that the server handled a request, closing the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.consecutiveFailures = 0
+}
+
+// Failure records that the server could not handle a request, opening the breaker after
+// the threshold of consecutive failures or on a failed trial
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures += 1
+	if b.state == BreakerHalfOpen || b.consecutiveFailures >= breakerThreshold() {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release lets go of a trial that never reached the server so that another request can be tried
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trialStarted = time.Time{}
+	}
+}
+
+// Status returns the state of the breaker
+func (b *CircuitBreaker) Status() CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := CircuitBreakerStatus{
+		ServerID: b.serverID, State: b.state, ConsecutiveFailures: b.consecutiveFailures}
+	if server, ok := ServerMap[strconv.FormatInt(int64(b.serverID), 10)]; ok {
+		status.ServerName = server.Name()
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(breakerCooldown())
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	return status
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-dispatcher2/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// commandOutputLimit is the most output kept from each of a command's stdout and stderr
+const commandOutputLimit = 64 * 1024
+
+// CommandPath returns the path of the binary registered for an allow-listed command name
+func CommandPath(name string) (string, error) {
+	path, ok := config.Dispatcher2Conf.Commands[name]
+	if !ok || len(name) == 0 {
+		return "", fmt.Errorf("command '%s' is not registered", name)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("command '%s' must be registered with an absolute path", name)
+	}
+	return path, nil
+}
+
+// RunCommand runs the registered command of a command schedule with the schedule's arguments.
+// The binary is executed directly and never through a shell, with a minimal environment and
+// under the configured command timeout. The command is killed when ctx is done.
+func (s *Schedule) RunCommand(ctx context.Context) (ScheduleRun, error) {
+	run := NewScheduleRun(s.ID)
+	path, err := CommandPath(s.Command)
+	if err != nil {
+		run.Finished = run.Started
+		return run, err
+	}
+	args, err := SplitCommandArgs(s.CommandArgs)
+	if err != nil {
+		run.Finished = run.Started
+		return run, err
+	}
+
+	timeout := config.Dispatcher2Conf.Server.CommandTimeout
+	if timeout <= 0 {
+		timeout = 300
+	}
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: commandOutputLimit}
+	stderr := &limitedBuffer{limit: commandOutputLimit}
+	cmd := exec.CommandContext(runCtx, path, args...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME"), "TZ=" + Location.String()}
+	cmd.Dir = filepath.Dir(path)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// don't wait forever on output pipes held open by children of a killed command
+	cmd.WaitDelay = 5 * time.Second
+
+	err = cmd.Run()
+	run.Finished = time.Now().In(Location)
+	run.Stdout = excerptText(stdout.String(), commandOutputLimit)
+	run.Stderr = excerptText(stderr.String(), commandOutputLimit)
+	if cmd.ProcessState != nil {
+		exitCode := cmd.ProcessState.ExitCode()
+		run.ExitCode = &exitCode
+	}
+	if ctx.Err() != nil {
+		return run, fmt.Errorf("command '%s' was stopped at shutdown", s.Command)
+	}
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return run, fmt.Errorf("command '%s' timed out after %d seconds", s.Command, timeout)
+	}
+	return run, err
+}
+
+// SplitCommandArgs splits command arguments on white space keeping single or double quoted arguments together
+func SplitCommandArgs(commandArgs string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	for _, c := range commandArgs {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command arguments: %s", commandArgs)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// limitedBuffer keeps up to limit bytes written to it and silently drops the rest
+type limitedBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); room > 0 {
+		if len(p) > room {
+			b.buf = append(b.buf, p[:room]...)
+		} else {
+			b.buf = append(b.buf, p...)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string { return string(b.buf) }
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go-dispatcher2/utils/dbutils"
+	"strings"
+	"time"
+)
+
+// DeadLetter is a request that will not be retried any more, having run out of retries or failed permanently
+type DeadLetter struct {
+	ID              RequestID     `db:"id" json:"id"`
+	UID             string        `db:"uid" json:"uid"`
+	Source          int64         `db:"source" json:"source"`
+	Destination     int64         `db:"destination" json:"destination"`
+	Status          RequestStatus `db:"status" json:"status"`
+	StatusCode      string        `db:"statuscode" json:"statusCode"`
+	Errors          string        `db:"errors" json:"errors"`
+	FailureClass    string        `db:"failure_class" json:"failureClass"`
+	Retries         int           `db:"retries" json:"retries"`
+	FailedCCServers pq.Int32Array `db:"failed_cc_servers" json:"failedCCServers"`
+	Created         time.Time     `db:"created" json:"created"`
+	Updated         time.Time     `db:"updated" json:"updated"`
+}
+
+// DeadLetterFilter selects dead letters. Dates are either dates or RFC3339 times and are matched
+// against when the request was last updated. Empty fields match all dead letters
+type DeadLetterFilter struct {
+	UID          string `form:"-" json:"-"`
+	Destination  int64  `form:"destination" json:"destination"`
+	StatusCode   string `form:"statusCode" json:"statusCode"`
+	FailureClass string `form:"failureClass" json:"failureClass"`
+	Since        string `form:"since" json:"since"`
+	Until        string `form:"until" json:"until"`
+}
+
+// IsEmpty returns whether the filter matches all dead letters
+func (f DeadLetterFilter) IsEmpty() bool {
+	return f == DeadLetterFilter{}
+}
+
+// ErrNoDeadLetters is returned when no dead letters could be replayed
+var ErrNoDeadLetters = errors.New("no dead letters to replay")
+
+// deadLetterStatuses are the statuses of requests that are no longer retried
+const deadLetterStatuses = `('expired', 'error')`
+
+// where returns the conditions and arguments selecting the dead letters matched by the filter
+func (f DeadLetterFilter) where() (string, []interface{}, error) {
+	conditions := []string{"status IN " + deadLetterStatuses}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(f.UID) > 0 {
+		add("uid = $%d", f.UID)
+	}
+	if f.Destination > 0 {
+		add("destination = $%d", f.Destination)
+	}
+	if len(f.StatusCode) > 0 {
+		add("statuscode = $%d", f.StatusCode)
+	}
+	if len(f.FailureClass) > 0 {
+		add("failure_class = $%d", f.FailureClass)
+	}
+	for _, d := range []struct{ value, condition string }{
+		{f.Since, "updated >= $%d"}, {f.Until, "updated <= $%d"}} {
+		if len(d.value) == 0 {
+			continue
+		}
+		date, err := parseFilterDate(d.value)
+		if err != nil {
+			return "", nil, err
+		}
+		add(d.condition, date)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// parseFilterDate reads a date or RFC3339 time in a filter
+func parseFilterDate(value string) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, Location); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', use YYYY-MM-DD or RFC3339", value)
+	}
+	return date, nil
+}
+
+// ListDeadLetters returns a page of the dead letters matched by the filter, most recently updated first
+func ListDeadLetters(db *sqlx.DB, filter DeadLetterFilter, page, pageSize string) (dbutils.Paginator, []DeadLetter, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return dbutils.Paginator{}, nil, err
+	}
+	var count int64
+	if err = db.Get(&count, "SELECT COUNT(*) FROM requests WHERE "+where, args...); err != nil {
+		return dbutils.Paginator{}, nil, err
+	}
+	pager := dbutils.GetPaginator(count, pageSize, page, true)
+
+	deadLetters := []DeadLetter{}
+	err = db.Select(&deadLetters, fmt.Sprintf(`
+	SELECT id, uid, source, destination, status, statuscode, errors, failure_class, retries,
+		undelivered_cc_servers(cc_servers, cc_servers_status) AS failed_cc_servers, created, updated
+	FROM requests WHERE %s ORDER BY updated DESC, id DESC LIMIT %d OFFSET %d`,
+		where, pager.PageSize, pager.Offset), args...)
+	if err != nil {
+		return pager, nil, err
+	}
+	return pager, deadLetters, nil
+}
+
+// ReplayDeadLetters puts the dead letters matched by the filter back in the queue with their retries reset
+// and returns the UIDs of those replayed. Their CC servers and copies to CC URLs that weren't delivered to,
+// including those that failed permanently, are reset to be retried from scratch too. With failedCCServersOnly only the failed CC
+// servers are retried, which leaves out dead letters that were never delivered to their destination.
+func ReplayDeadLetters(tx *sqlx.Tx, filter DeadLetterFilter, failedCCServersOnly bool) ([]string, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	status := "'ready', retries = 0, statuscode = '', errors = '', failure_class = ''"
+	if failedCCServersOnly {
+		// only requests delivered to their destination and expired on their CC servers
+		where += " AND failure_class = '' AND statuscode LIKE '2%'"
+		status = "'completed'"
+	}
+
+	_, err = tx.Exec(`SELECT replay_request_cc_servers(id, undelivered_cc_servers(cc_servers, cc_servers_status))
+	FROM requests WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	uids := []string{}
+	err = tx.Select(&uids, fmt.Sprintf(`UPDATE requests SET status = %s, next_attempt_at = NULL,
+		updated = current_timestamp WHERE %s RETURNING uid`, status, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, ErrNoDeadLetters
+	}
+	return uids, nil
+}
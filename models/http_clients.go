@@ -0,0 +1,189 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-dispatcher2/config"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// httpClientSettings are the settings a server's HTTP client is built from.
+// The client is rebuilt when they change, e.g. after the server's configuration is reloaded
+type httpClientSettings struct {
+	useSSL             bool
+	certKeyFile        string
+	insecureSkipVerify bool
+	connectTimeout     time.Duration
+	readTimeout        time.Duration
+	maxInFlight        int
+	url                string
+	authMethod         string
+	username           string
+	password           string
+	authToken          string
+	oauth2TokenURL     string
+	oauth2ClientID     string
+	oauth2ClientSecret string
+	oauth2Scopes       string
+	apiKeyHeader       string
+	hmacHeader         string
+}
+
+// pooledClient is the HTTP client shared by all requests to a server, and the REST client built on it
+type pooledClient struct {
+	settings   httpClientSettings
+	httpClient *http.Client
+	restClient *Client
+}
+
+var (
+	pooledClients     = make(map[ServerID]*pooledClient)
+	pooledClientsMu   sync.Mutex
+	defaultHTTPClient *http.Client
+	defaultClientErr  error
+	defaultClientOnce sync.Once
+)
+
+// timeoutSeconds returns seconds as a duration, falling back to the global seconds and then defaultSeconds
+func timeoutSeconds(seconds, globalSeconds, defaultSeconds int) time.Duration {
+	switch {
+	case seconds > 0:
+		return time.Duration(seconds) * time.Second
+	case globalSeconds > 0:
+		return time.Duration(globalSeconds) * time.Second
+	default:
+		return time.Duration(defaultSeconds) * time.Second
+	}
+}
+
+// httpClientSettings returns the settings of the server's HTTP client. Servers without their own
+// client certificate or timeouts use the global ones
+func (s *Server) httpClientSettings() httpClientSettings {
+	certKeyFile := s.s.SSLClientCertKeyFile
+	if len(certKeyFile) == 0 {
+		certKeyFile = config.Dispatcher2Conf.Server.SSLClientCertKeyFile
+	}
+	return httpClientSettings{
+		useSSL:             s.s.UseSSL,
+		certKeyFile:        certKeyFile,
+		insecureSkipVerify: s.s.InsecureSkipVerify,
+		connectTimeout:     timeoutSeconds(s.s.ConnectTimeout, config.Dispatcher2Conf.Server.HTTPConnectTimeout, 10),
+		readTimeout:        timeoutSeconds(s.s.ReadTimeout, config.Dispatcher2Conf.Server.HTTPReadTimeout, 60),
+		maxInFlight:        s.s.MaxInFlight,
+		url:                s.s.URL,
+		authMethod:         s.s.AuthMethod,
+		username:           s.s.Username,
+		password:           s.s.Password,
+		authToken:          s.s.AuthToken,
+		oauth2TokenURL:     s.s.OAuth2TokenURL,
+		oauth2ClientID:     s.s.OAuth2ClientID,
+		oauth2ClientSecret: s.s.OAuth2ClientSecret,
+		oauth2Scopes:       s.s.OAuth2Scopes,
+		apiKeyHeader:       s.s.APIKeyHeader,
+		hmacHeader:         s.s.HMACHeader,
+	}
+}
+
+// newTLSConfig returns the TLS configuration for the settings. Certificates are verified against the
+// system's CAs and the global ssl_trusted_cafile unless the server opts out with insecureSkipVerify.
+// Servers using SSL are presented with the client certificate for mutual TLS
+func newTLSConfig(settings httpClientSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: settings.insecureSkipVerify}
+	if caFile := config.Dispatcher2Conf.Server.SSLTrustedCAFile; len(caFile) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted CA file: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in trusted CA file")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if settings.useSSL && len(settings.certKeyFile) > 0 {
+		// the certificate and its key are kept in the same PEM file
+		pem, err := os.ReadFile(settings.certKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %w", err)
+		}
+		cert, err := tls.X509KeyPair(pem, pem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newHTTPClient returns an HTTP client for the settings that keeps connections alive for reuse
+func newHTTPClient(settings httpClientSettings) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   settings.connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   settings.connectTimeout,
+		ResponseHeaderTimeout: settings.readTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   max(settings.maxInFlight, 10),
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+	return &http.Client{Transport: transport, Timeout: settings.connectTimeout + settings.readTimeout}, nil
+}
+
+// pooledClientFor returns the pooled client of the server, building it on first use or when its settings change
+func (s *Server) pooledClientFor() (*pooledClient, error) {
+	settings := s.httpClientSettings()
+	pooledClientsMu.Lock()
+	defer pooledClientsMu.Unlock()
+	if pooled, ok := pooledClients[s.ID()]; ok && pooled.settings == settings {
+		return pooled, nil
+	}
+	httpClient, err := newHTTPClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	if previous, ok := pooledClients[s.ID()]; ok {
+		previous.httpClient.CloseIdleConnections()
+	}
+	pooled := &pooledClient{settings: settings, httpClient: httpClient}
+	pooledClients[s.ID()] = pooled
+	return pooled, nil
+}
+
+// HTTPClient returns the HTTP client shared by all requests to the server
+func (s *Server) HTTPClient() (*http.Client, error) {
+	pooled, err := s.pooledClientFor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up HTTP client for server %d: %w", s.ID(), err)
+	}
+	return pooled.httpClient, nil
+}
+
+// DefaultHTTPClient returns the HTTP client shared by requests to URLs that aren't those of a server,
+// e.g. copies to CC URLs and callbacks. It uses the global timeouts and verifies certificates
+func DefaultHTTPClient() (*http.Client, error) {
+	defaultClientOnce.Do(func() {
+		defaultHTTPClient, defaultClientErr = newHTTPClient(httpClientSettings{
+			connectTimeout: timeoutSeconds(0, config.Dispatcher2Conf.Server.HTTPConnectTimeout, 10),
+			readTimeout:    timeoutSeconds(0, config.Dispatcher2Conf.Server.HTTPReadTimeout, 60),
+		})
+	})
+	return defaultHTTPClient, defaultClientErr
+}
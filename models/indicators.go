@@ -1,6 +1,16 @@
 package models
 
-import "time"
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 // Dhis2IndicatorMapping holds the DHIS 2 indicator mappings
 type Dhis2IndicatorMapping struct {
@@ -12,10 +22,247 @@ type Dhis2IndicatorMapping struct {
 	Slug                 string    `db:"slug" json:"slug"`
 	Cmd                  string    `db:"cmd" json:"cmd"`
 	FormOrder            string    `db:"form_order" json:"form_order"`
-	DataElement          string    `db:"dataelement" json:""`
+	DataElement          string    `db:"dataelement" json:"dataelement"`
+	CategoryOptionCombo  string    `db:"category_option_combo" json:"category_option_combo"`
 	DataSet              string    `db:"dataset" json:"dataset"`
 	AttributeOptionCombo string    `db:"attribute_option_combo" json:"attribute_option_combo"`
 	CategoryCombo        string    `db:"category_combo" json:"category_combo"`
 	Created              time.Time `db:"created" json:"created"`
 	Updated              time.Time `db:"updated" json:"updated"`
 }
+
+// IndicatorMappingCSVHeader are the columns of indicator mappings in CSV
+var IndicatorMappingCSVHeader = []string{
+	"uid", "name", "description", "form", "slug", "cmd", "form_order", "dataelement",
+	"category_option_combo", "dataset", "attribute_option_combo", "category_combo"}
+
+const insertIndicatorMappingSQL = `
+INSERT INTO dhis2_indicator_mapping (uid, name, description, form, slug, cmd, form_order, dataelement,
+		category_option_combo, dataset, attribute_option_combo, category_combo, created, updated)
+	VALUES (COALESCE(NULLIF(:uid, ''), generate_uid()), :name, :description, :form, :slug, :cmd, :form_order,
+		:dataelement, :category_option_combo, :dataset, :attribute_option_combo, :category_combo, now(), now())`
+
+const updateIndicatorMappingSQL = `
+UPDATE dhis2_indicator_mapping SET name = :name, description = :description, form = :form, slug = :slug,
+		cmd = :cmd, form_order = :form_order, dataelement = :dataelement,
+		category_option_combo = :category_option_combo, dataset = :dataset,
+		attribute_option_combo = :attribute_option_combo, category_combo = :category_combo, updated = now()
+	WHERE id = :id`
+
+// Validate checks that the mapping says where its value comes from and where it goes
+func (m *Dhis2IndicatorMapping) Validate() error {
+	if len(m.Slug) == 0 || len(m.DataElement) == 0 || len(m.DataSet) == 0 {
+		return fmt.Errorf("indicator mapping '%s' needs a slug, dataelement and dataset", m.Name)
+	}
+	return nil
+}
+
+// ListIndicatorMappings returns all indicator mappings
+func ListIndicatorMappings(db *sqlx.DB) ([]Dhis2IndicatorMapping, error) {
+	mappings := []Dhis2IndicatorMapping{}
+	err := db.Select(&mappings, `SELECT * FROM dhis2_indicator_mapping ORDER BY dataset, form, form_order, slug`)
+	return mappings, err
+}
+
+// GetIndicatorMapping retrieves an indicator mapping from the database by ID
+func GetIndicatorMapping(db *sqlx.DB, id int64) (Dhis2IndicatorMapping, error) {
+	var mapping Dhis2IndicatorMapping
+	err := db.Get(&mapping, `SELECT * FROM dhis2_indicator_mapping WHERE id = $1`, id)
+	return mapping, err
+}
+
+// CreateIndicatorMapping inserts a new indicator mapping and returns its id
+func CreateIndicatorMapping(db *sqlx.DB, mapping Dhis2IndicatorMapping) (int64, error) {
+	var id int64
+	rows, err := db.NamedQuery(insertIndicatorMappingSQL+" RETURNING id", &mapping)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+	if rows.Next() {
+		err = rows.Scan(&id)
+	} else {
+		// errors executing the insert, e.g. a duplicate slug, come after the query is described
+		err = rows.Err()
+	}
+	InvalidateIndicatorMappings()
+	return id, err
+}
+
+// UpdateIndicatorMapping updates an existing indicator mapping
+func UpdateIndicatorMapping(db *sqlx.DB, mapping Dhis2IndicatorMapping) error {
+	_, err := db.NamedExec(updateIndicatorMappingSQL, &mapping)
+	InvalidateIndicatorMappings()
+	return err
+}
+
+// DeleteIndicatorMapping deletes an indicator mapping by ID
+func DeleteIndicatorMapping(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM dhis2_indicator_mapping WHERE id = $1`, id)
+	InvalidateIndicatorMappings()
+	return err
+}
+
+// ImportIndicatorMappings reads indicator mappings from CSV with a header row naming the columns, as written by
+// ExportIndicatorMappings. Mappings of a dataset and slug already present are replaced. It returns the mappings
+// imported; none are when any row is invalid.
+func ImportIndicatorMappings(db *sqlx.DB, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	imported := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		mapping := Dhis2IndicatorMapping{
+			UID: value("uid"), Name: value("name"), Description: value("description"), Form: value("form"),
+			Slug: value("slug"), Cmd: value("cmd"), FormOrder: value("form_order"), DataElement: value("dataelement"),
+			CategoryOptionCombo: value("category_option_combo"), DataSet: value("dataset"),
+			AttributeOptionCombo: value("attribute_option_combo"), CategoryCombo: value("category_combo")}
+		if err := mapping.Validate(); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		_, err = tx.NamedExec(insertIndicatorMappingSQL+`
+		ON CONFLICT (dataset, LOWER(slug)) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description,
+			form = EXCLUDED.form, slug = EXCLUDED.slug, cmd = EXCLUDED.cmd, form_order = EXCLUDED.form_order,
+			dataelement = EXCLUDED.dataelement, category_option_combo = EXCLUDED.category_option_combo,
+			attribute_option_combo = EXCLUDED.attribute_option_combo, category_combo = EXCLUDED.category_combo,
+			updated = now()`, &mapping)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		imported += 1
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	InvalidateIndicatorMappings()
+	return imported, nil
+}
+
+// ExportIndicatorMappings writes the indicator mappings as CSV with a header row
+func ExportIndicatorMappings(w io.Writer, mappings []Dhis2IndicatorMapping) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(IndicatorMappingCSVHeader); err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		err := writer.Write([]string{m.UID, m.Name, m.Description, m.Form, m.Slug, m.Cmd, m.FormOrder,
+			m.DataElement, m.CategoryOptionCombo, m.DataSet, m.AttributeOptionCombo, m.CategoryCombo})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// indicatorMappingsTTL is how long cached mappings are used before they are reloaded,
+// which picks up changes made through other instances
+const indicatorMappingsTTL = time.Minute
+
+// indicatorMappingCache holds the indicator mappings by dataset and slug and by report keyword
+type indicatorMappingCache struct {
+	byDataSet map[string]map[string]Dhis2IndicatorMapping
+	byCmd     map[string][]Dhis2IndicatorMapping
+	loaded    time.Time
+}
+
+var (
+	indicatorMappings   *indicatorMappingCache
+	indicatorMappingsMu sync.Mutex
+)
+
+// InvalidateIndicatorMappings makes the next lookup reload the indicator mappings
+func InvalidateIndicatorMappings() {
+	indicatorMappingsMu.Lock()
+	defer indicatorMappingsMu.Unlock()
+	indicatorMappings = nil
+}
+
+// cachedIndicatorMappings returns the cached indicator mappings, loading them if they are missing or stale
+func cachedIndicatorMappings(db *sqlx.DB) (*indicatorMappingCache, error) {
+	indicatorMappingsMu.Lock()
+	defer indicatorMappingsMu.Unlock()
+	if indicatorMappings != nil && time.Since(indicatorMappings.loaded) < indicatorMappingsTTL {
+		return indicatorMappings, nil
+	}
+	mappings, err := ListIndicatorMappings(db)
+	if err != nil {
+		return nil, err
+	}
+	cache := &indicatorMappingCache{
+		byDataSet: make(map[string]map[string]Dhis2IndicatorMapping),
+		byCmd:     make(map[string][]Dhis2IndicatorMapping),
+		loaded:    time.Now(),
+	}
+	for _, mapping := range mappings {
+		if _, ok := cache.byDataSet[mapping.DataSet]; !ok {
+			cache.byDataSet[mapping.DataSet] = make(map[string]Dhis2IndicatorMapping)
+		}
+		cache.byDataSet[mapping.DataSet][strings.ToLower(mapping.Slug)] = mapping
+		if len(mapping.Cmd) > 0 {
+			cmd := strings.ToLower(mapping.Cmd)
+			cache.byCmd[cmd] = append(cache.byCmd[cmd], mapping)
+		}
+	}
+	for _, cmdMappings := range cache.byCmd {
+		sort.SliceStable(cmdMappings, func(i, j int) bool {
+			return formOrderLess(cmdMappings[i].FormOrder, cmdMappings[j].FormOrder)
+		})
+	}
+	indicatorMappings = cache
+	return cache, nil
+}
+
+// formOrderLess orders form orders numerically when they are numbers
+func formOrderLess(a, b string) bool {
+	x, errX := strconv.Atoi(a)
+	y, errY := strconv.Atoi(b)
+	if errX == nil && errY == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// GetDataSetIndicatorMappings returns the mappings of a data set keyed by their lower case slug
+func GetDataSetIndicatorMappings(db *sqlx.DB, dataSet string) (map[string]Dhis2IndicatorMapping, error) {
+	cache, err := cachedIndicatorMappings(db)
+	if err != nil {
+		return nil, err
+	}
+	return cache.byDataSet[dataSet], nil
+}
+
+// GetKeywordIndicatorMappings returns the mappings of the cmd keyword of an SMS report in form order
+func GetKeywordIndicatorMappings(db *sqlx.DB, keyword string) ([]Dhis2IndicatorMapping, error) {
+	cache, err := cachedIndicatorMappings(db)
+	if err != nil {
+		return nil, err
+	}
+	return cache.byCmd[strings.ToLower(keyword)], nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	weeklyPeriod    = regexp.MustCompile(`^(\d{4})W(\d{1,2})$`)
+	quarterlyPeriod = regexp.MustCompile(`^(\d{4})Q([1-4])$`)
+)
+
+// PeriodDate returns the year, ISO week and month of a DHIS 2 period, e.g. 2024W5, 20240115, 202401, 2024Q1
+// or 2024. The week and month of periods longer than a day are those of the period's first day, while the year
+// of weekly periods is their ISO year
+func PeriodDate(period string) (year, week, month int, err error) {
+	if m := weeklyPeriod.FindStringSubmatch(period); m != nil {
+		year, _ = strconv.Atoi(m[1])
+		week, _ = strconv.Atoi(m[2])
+		// ISO week 1 is the week with January 4th in it
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		monday := jan4.AddDate(0, 0, (week-1)*7-(int(jan4.Weekday())+6)%7)
+		if y, w := monday.ISOWeek(); y != year || w != week {
+			return 0, 0, 0, fmt.Errorf("invalid period %s: %d has no week %d", period, year, week)
+		}
+		return year, week, int(monday.Month()), nil
+	}
+
+	var start time.Time
+	if m := quarterlyPeriod.FindStringSubmatch(period); m != nil {
+		year, _ = strconv.Atoi(m[1])
+		quarter, _ := strconv.Atoi(m[2])
+		start = time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+	} else {
+		layouts := map[int]string{8: "20060102", 6: "200601", 4: "2006"}
+		layout, ok := layouts[len(period)]
+		if !ok {
+			return 0, 0, 0, fmt.Errorf("invalid period %s", period)
+		}
+		if start, err = time.Parse(layout, period); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid period %s", period)
+		}
+	}
+	_, week = start.ISOWeek()
+	return start.Year(), week, int(start.Month()), nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodDate(t *testing.T) {
+	tcs := []struct {
+		period   string
+		year     int
+		week     int
+		month    int
+		hasError bool
+	}{
+		{period: "2024W5", year: 2024, week: 5, month: 1},
+		{period: "2024W1", year: 2024, week: 1, month: 1},
+		{period: "2025W1", year: 2025, week: 1, month: 12}, // starts on 2024-12-30
+		{period: "2020W53", year: 2020, week: 53, month: 12},
+		{period: "2024W53", hasError: true},
+		{period: "2024W0", hasError: true},
+		{period: "20240115", year: 2024, week: 3, month: 1},
+		{period: "202403", year: 2024, week: 9, month: 3},
+		{period: "202301", year: 2023, week: 52, month: 1}, // 2023-01-01 is in the last week of 2022
+		{period: "2024Q3", year: 2024, week: 27, month: 7},
+		{period: "2024", year: 2024, week: 1, month: 1},
+		{period: "202413", hasError: true},
+		{period: "May 2024", hasError: true},
+		{period: "", hasError: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.period, func(t *testing.T) {
+			year, week, month, err := PeriodDate(tc.period)
+			if tc.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []int{tc.year, tc.week, tc.month}, []int{year, week, month})
+		})
+	}
+}
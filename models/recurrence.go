@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// NextRun returns the first time after from that the schedule should run, in Location.
+// The returned bool is false if the schedule does not repeat.
+//
+// Calendar repeats (hourly to yearly) are counted from the schedule's first run so that runs
+// don't drift with processing delays, and a monthly schedule on the 31st runs on the last day
+// of shorter months. Runs missed while the dispatcher was down are skipped.
+func (s *Schedule) NextRun(from time.Time) (time.Time, bool, error) {
+	from = from.In(Location)
+	anchor := from
+	if s.FirstRunAt.Valid && !s.FirstRunAt.Time.IsZero() {
+		anchor = s.FirstRunAt.Time.In(Location)
+	} else if !s.NextRunAt.IsZero() {
+		anchor = s.NextRunAt.In(Location)
+	}
+
+	switch s.Repeat {
+	case "hourly":
+		return nextOccurrence(anchor, from, int(from.Sub(anchor)/time.Hour), func(n int) time.Time {
+			return anchor.Add(time.Duration(n) * time.Hour)
+		}), true, nil
+	case "daily":
+		return nextOccurrence(anchor, from, int(from.Sub(anchor)/(24*time.Hour)), func(n int) time.Time {
+			return anchor.AddDate(0, 0, n)
+		}), true, nil
+	case "weekly":
+		return nextOccurrence(anchor, from, int(from.Sub(anchor)/(7*24*time.Hour)), func(n int) time.Time {
+			return anchor.AddDate(0, 0, 7*n)
+		}), true, nil
+	case "monthly":
+		return nextOccurrence(anchor, from, monthsBetween(anchor, from), func(n int) time.Time {
+			return addMonths(anchor, n)
+		}), true, nil
+	case "yearly":
+		return nextOccurrence(anchor, from, from.Year()-anchor.Year(), func(n int) time.Time {
+			return addMonths(anchor, 12*n)
+		}), true, nil
+	case "interval":
+		if s.RepeatInterval <= 0 {
+			return time.Time{}, false, fmt.Errorf("invalid repeat interval %d for schedule %d", s.RepeatInterval, s.ID)
+		}
+		interval := time.Second * time.Duration(s.RepeatInterval)
+		start := from
+		if !s.NextRunAt.IsZero() {
+			start = s.NextRunAt.In(Location)
+		}
+		return nextOccurrence(start, from, int(from.Sub(start)/interval), func(n int) time.Time {
+			return start.Add(time.Duration(n) * interval)
+		}), true, nil
+	case "cron":
+		cronSchedule, err := cron.ParseStandard(s.CronExpression)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid cron expression '%s' for schedule %d: %w",
+				s.CronExpression, s.ID, err)
+		}
+		return cronSchedule.Next(from), true, nil
+	case "never", "":
+		return time.Time{}, false, nil
+	default:
+		return time.Time{}, false, fmt.Errorf("unknown repeat '%s' for schedule %d", s.Repeat, s.ID)
+	}
+}
+
+// Reschedule records a run of the schedule that ended with status and moves it to its next run.
+// Repeating schedules go back to ready while schedules that never repeat are completed
+// unless the run failed.
+func (s *Schedule) Reschedule(tx *sqlx.Tx, status string) error {
+	nextRun, repeats, err := s.NextRun(time.Now())
+	if err != nil {
+		_ = s.UpdateRunDetails(tx, "error", s.NextRunAt)
+		return err
+	}
+	switch {
+	case repeats:
+		status = "ready"
+	case status != "failed" && status != "expired":
+		status = "completed"
+	}
+	if !repeats {
+		nextRun = s.NextRunAt
+	}
+	return s.UpdateRunDetails(tx, status, nextRun)
+}
+
+// nextOccurrence returns the first of anchor's occurrences, at(1), at(2) ..., that is after from.
+// guess is an estimate of the number of occurrences up to from and only saves on iterations.
+func nextOccurrence(anchor, from time.Time, guess int, at func(n int) time.Time) time.Time {
+	if anchor.After(from) {
+		return anchor
+	}
+	n := guess - 1
+	if n < 1 {
+		n = 1
+	}
+	for !at(n).After(from) {
+		n++
+	}
+	// step back in case the guess overshot, e.g. across a DST change
+	for n > 1 && at(n-1).After(from) {
+		n--
+	}
+	return at(n)
+}
+
+// monthsBetween returns the number of calendar months from a to b
+func monthsBetween(a, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
+
+// addMonths adds months to t keeping its day of the month, clamped to the last day of shorter months
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1,
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if lastDay := first.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return time.Date(first.Year(), first.Month(), day,
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleNextRun(t *testing.T) {
+	kampala, err := time.LoadLocation("Africa/Kampala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Location = kampala
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, kampala)
+	}
+	firstRun := func(t time.Time) NullTime {
+		return NullTime{sql.NullTime{Time: t, Valid: true}}
+	}
+
+	tcs := []struct {
+		name     string
+		schedule Schedule
+		from     time.Time
+		next     time.Time
+		repeats  bool
+		hasError bool
+	}{
+		{
+			name:     "never",
+			schedule: Schedule{Repeat: "never", NextRunAt: at(2024, 1, 1, 8, 0)},
+			from:     at(2024, 1, 1, 8, 0),
+		},
+		{
+			name:     "hourly",
+			schedule: Schedule{Repeat: "hourly", FirstRunAt: firstRun(at(2024, 1, 1, 8, 15))},
+			from:     at(2024, 3, 5, 10, 20),
+			next:     at(2024, 3, 5, 11, 15),
+			repeats:  true,
+		},
+		{
+			name:     "hourly across midnight",
+			schedule: Schedule{Repeat: "hourly", FirstRunAt: firstRun(at(2024, 1, 1, 0, 0))},
+			from:     at(2024, 1, 1, 23, 0),
+			next:     at(2024, 1, 2, 0, 0),
+			repeats:  true,
+		},
+		{
+			name:     "daily does not drift with processing delays",
+			schedule: Schedule{Repeat: "daily", FirstRunAt: firstRun(at(2024, 1, 1, 8, 0))},
+			from:     at(2024, 1, 10, 8, 3),
+			next:     at(2024, 1, 11, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "daily skips missed runs",
+			schedule: Schedule{Repeat: "daily", FirstRunAt: firstRun(at(2024, 1, 1, 8, 0))},
+			from:     at(2024, 2, 20, 9, 0),
+			next:     at(2024, 2, 21, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "weekly",
+			schedule: Schedule{Repeat: "weekly", FirstRunAt: firstRun(at(2024, 1, 1, 8, 0))},
+			from:     at(2024, 1, 1, 8, 0),
+			next:     at(2024, 1, 8, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "weekly over year rollover",
+			schedule: Schedule{Repeat: "weekly", FirstRunAt: firstRun(at(2024, 12, 27, 8, 0))},
+			from:     at(2024, 12, 27, 8, 0),
+			next:     at(2025, 1, 3, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "monthly",
+			schedule: Schedule{Repeat: "monthly", FirstRunAt: firstRun(at(2024, 1, 15, 6, 0))},
+			from:     at(2024, 1, 15, 6, 0),
+			next:     at(2024, 2, 15, 6, 0),
+			repeats:  true,
+		},
+		{
+			name:     "monthly on the 31st into a leap February",
+			schedule: Schedule{Repeat: "monthly", FirstRunAt: firstRun(at(2024, 1, 31, 6, 0))},
+			from:     at(2024, 1, 31, 6, 0),
+			next:     at(2024, 2, 29, 6, 0),
+			repeats:  true,
+		},
+		{
+			name:     "monthly on the 31st back to a long month",
+			schedule: Schedule{Repeat: "monthly", FirstRunAt: firstRun(at(2024, 1, 31, 6, 0))},
+			from:     at(2024, 2, 29, 6, 0),
+			next:     at(2024, 3, 31, 6, 0),
+			repeats:  true,
+		},
+		{
+			name:     "monthly on the 31st into a 30 day month",
+			schedule: Schedule{Repeat: "monthly", FirstRunAt: firstRun(at(2024, 1, 31, 6, 0))},
+			from:     at(2024, 3, 31, 6, 0),
+			next:     at(2024, 4, 30, 6, 0),
+			repeats:  true,
+		},
+		{
+			name:     "monthly over year rollover",
+			schedule: Schedule{Repeat: "monthly", FirstRunAt: firstRun(at(2024, 10, 31, 6, 0))},
+			from:     at(2024, 12, 31, 6, 0),
+			next:     at(2025, 1, 31, 6, 0),
+			repeats:  true,
+		},
+		{
+			name:     "yearly",
+			schedule: Schedule{Repeat: "yearly", FirstRunAt: firstRun(at(2023, 7, 1, 0, 0))},
+			from:     at(2024, 7, 1, 0, 0),
+			next:     at(2025, 7, 1, 0, 0),
+			repeats:  true,
+		},
+		{
+			name:     "yearly on a leap day",
+			schedule: Schedule{Repeat: "yearly", FirstRunAt: firstRun(at(2024, 2, 29, 0, 0))},
+			from:     at(2024, 2, 29, 0, 0),
+			next:     at(2025, 2, 28, 0, 0),
+			repeats:  true,
+		},
+		{
+			name:     "yearly on the last day of the year",
+			schedule: Schedule{Repeat: "yearly", FirstRunAt: firstRun(at(2023, 12, 31, 23, 0))},
+			from:     at(2024, 1, 1, 0, 0),
+			next:     at(2024, 12, 31, 23, 0),
+			repeats:  true,
+		},
+		{
+			name:     "first run still in the future",
+			schedule: Schedule{Repeat: "daily", FirstRunAt: firstRun(at(2024, 6, 1, 8, 0))},
+			from:     at(2024, 5, 1, 8, 0),
+			next:     at(2024, 6, 1, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "interval",
+			schedule: Schedule{Repeat: "interval", RepeatInterval: 30, NextRunAt: at(2024, 1, 1, 8, 0)},
+			from:     at(2024, 1, 1, 8, 0),
+			next:     at(2024, 1, 1, 8, 0).Add(30 * time.Second),
+			repeats:  true,
+		},
+		{
+			name:     "interval skips missed runs",
+			schedule: Schedule{Repeat: "interval", RepeatInterval: 900, NextRunAt: at(2024, 1, 1, 8, 0)},
+			from:     at(2024, 1, 1, 9, 10),
+			next:     at(2024, 1, 1, 9, 15),
+			repeats:  true,
+		},
+		{
+			name:     "invalid interval",
+			schedule: Schedule{Repeat: "interval", RepeatInterval: 0},
+			from:     at(2024, 1, 1, 8, 0),
+			hasError: true,
+		},
+		{
+			name:     "cron",
+			schedule: Schedule{Repeat: "cron", CronExpression: "30 6 * * 1-5"},
+			from:     at(2024, 3, 8, 7, 0), // a Friday
+			next:     at(2024, 3, 11, 6, 30),
+			repeats:  true,
+		},
+		{
+			name:     "cron over month rollover",
+			schedule: Schedule{Repeat: "cron", CronExpression: "0 0 1 * *"},
+			from:     at(2024, 4, 30, 12, 0),
+			next:     at(2024, 5, 1, 0, 0),
+			repeats:  true,
+		},
+		{
+			name:     "cron over year rollover",
+			schedule: Schedule{Repeat: "cron", CronExpression: "0 8 * * *"},
+			from:     at(2024, 12, 31, 9, 0),
+			next:     at(2025, 1, 1, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "cron uses the deployment timezone",
+			schedule: Schedule{Repeat: "cron", CronExpression: "0 8 * * *"},
+			from:     at(2024, 1, 1, 4, 0).UTC(),
+			next:     at(2024, 1, 1, 8, 0),
+			repeats:  true,
+		},
+		{
+			name:     "invalid cron",
+			schedule: Schedule{Repeat: "cron", CronExpression: "every day"},
+			from:     at(2024, 1, 1, 8, 0),
+			hasError: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		next, repeats, err := tc.schedule.NextRun(tc.from)
+		if tc.hasError {
+			assert.Error(t, err, "expected error for %s", tc.name)
+			continue
+		}
+		assert.NoError(t, err, "unexpected error for %s", tc.name)
+		assert.Equal(t, tc.repeats, repeats, "repeats mismatch for %s", tc.name)
+		if tc.repeats {
+			assert.Equal(t, tc.next, next, "next run mismatch for %s", tc.name)
+			assert.Equal(t, kampala, next.Location(), "location mismatch for %s", tc.name)
+		}
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	tcs := []struct {
+		date     time.Time
+		months   int
+		expected time.Time
+	}{
+		{time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC), 1, time.Date(2023, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC), 2, time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 11, 30, 0, 0, 0, 0, time.UTC), 3, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 12, 15, 0, 0, 0, 0, time.UTC), 1, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), 12, time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), 48, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, addMonths(tc.date, tc.months), "addMonths(%s, %d)", tc.date, tc.months)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -8,13 +9,15 @@ import (
 	"github.com/lib/pq"
 	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/config"
 	db2 "go-dispatcher2/db"
-	// "go-dispatcher2/config"
 	"go-dispatcher2/utils"
 	"go-dispatcher2/utils/dbutils"
+	"mime"
 	"reflect"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -32,6 +35,7 @@ const (
 	RequestStatusExpired   = RequestStatus("expired")
 	RequestStatusCompleted = RequestStatus("completed")
 	RequestStatusFailed    = RequestStatus("failed")
+	RequestStatusError     = RequestStatus("error") // failed permanently
 	RequestStatusCanceled  = RequestStatus("canceled")
 )
 
@@ -213,43 +217,61 @@ func NewRequestFromPOST(c *gin.Context, db *sqlx.DB) (Request, error) {
 	//
 	//}
 	contentType := c.Request.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
 	year, week := time.Now().ISOWeek()
 	reqF := RequestForm{
 		Source:       src,
 		Destination:  dest,
-		ContentType:  "application/json",
+		ContentType:  contentType,
 		Year:         c.DefaultQuery("year", fmt.Sprintf("%d", year)),
 		Week:         c.DefaultQuery("week", fmt.Sprintf("%d", week)),
 		Month:        c.DefaultQuery("month", fmt.Sprintf("%d", int(time.Now().Month()))),
 		Period:       c.DefaultQuery("period", ""),
 		Facility:     c.DefaultQuery("facility", ""),
 		BatchID:      utils.GetUID(),
-		SubmissionID: c.DefaultQuery("submission_id", ""),
+		SubmissionID: c.DefaultQuery("submission_id", c.GetHeader("Idempotency-Key")),
 		District:     c.DefaultQuery("district", ""),
 		CCServers:    strings.Split(c.DefaultQuery("cc_servers", ""), ","),
 		// Body:      string(reqBody), ObjectType: "ORGANISATION_UNIT", ReportType: "OU",
+		BodyIsQueryParams: c.Query("isQueryParams") == "true",
 	}
 
-	// sourceName :=
-	switch contentType {
-	case "application/json", "application/json-patch+json", "application/geo+json":
-		var body map[string]interface{}
-		if err := c.BindJSON(&body); err != nil {
-			log.WithError(err).Error("Error reading request object from POST body")
-		}
-		b, _ := json.Marshal(body)
-		// fmt.Println(string(b))
-		reqF.Body = string(b)
-		*req, _ = reqF.Save(db)
-		// log.WithField("New Server", s).Info("Going to create new server")
-	default:
-		//
-		log.WithField("Content-Type", contentType).Error("Unsupported content-Type")
-		return *req, errors.New(fmt.Sprintf("Unsupported Content-Type: %s", contentType))
+	// the body is stored as is and forwarded verbatim with its content type
+	body, err := c.GetRawData()
+	if err != nil {
+		log.WithError(err).Error("Error reading request body from POST body")
+		return *req, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if err := ValidateRequestBody(contentType, body); err != nil {
+		log.WithError(err).WithField("Content-Type", contentType).Error("Invalid request body")
+		return *req, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	reqF.Body = string(body)
+	*req, err = reqF.Save(db)
+	if err != nil {
+		return *req, err
 	}
 	return *req, nil
 }
 
+// ValidateRequestBody checks that a request body can be stored and forwarded as text.
+// JSON bodies must also be valid JSON
+func ValidateRequestBody(contentType string, body []byte) error {
+	if !utf8.Valid(body) || bytes.IndexByte(body, 0) >= 0 {
+		return fmt.Errorf("request body of Content-Type %s is not text", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type: %s", contentType)
+	}
+	if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && !json.Valid(body) {
+		return errors.New("request body is not valid JSON")
+	}
+	return nil
+}
+
 func (r *Request) RequestDBFields() []string {
 	e := reflect.ValueOf(&r.r).Elem()
 	var ret []string
@@ -266,11 +288,12 @@ func (r *Request) RequestDBFields() []string {
 const insertRequestSQL = `
 INSERT INTO 
 requests (source, destination, depends_on, uid, batchid, content_type, body, body_is_query_param, period, week, month, year,
-			raw_msg, msisdn, facility, district, report_type, object_type, extras, url_suffix, cc_servers,
+			raw_msg, msisdn, facility, district, report_type, object_type, extras, url_suffix, cc_servers, submissionid,
 			created, updated) 
 	VALUES(:source, :destination, :depends_on, :uid, :batchid, :ctype, :body, :body_is_query_param, :period,
 			:week, :month, :year, :raw_msg, :msisdn, :facility, :district, :report_type, :object_type,
-			:extras, :url_suffix, :cc_servers, now(), now()) RETURNING id`
+			:extras, :url_suffix, :cc_servers, :submissionid, now(), now())
+	ON CONFLICT (source, submissionid) WHERE submissionid <> '' DO NOTHING RETURNING id`
 
 type RequestForm struct {
 	ID                RequestID   `db:"id" json:"-"`
@@ -313,10 +336,10 @@ func (rq *RequestForm) Save(db *sqlx.DB) (Request, error) {
 	destination := ServerMapByName[rq.Destination]
 	r.Destination = int(destination.ID())
 	if r.Source == 0 {
-		return *req, errors.New(fmt.Sprintf("Source server %s not found!", rq.Source))
+		return *req, fmt.Errorf("%w: source server %s not found", ErrInvalidRequest, rq.Source)
 	}
 	if r.Destination == 0 {
-		return *req, errors.New(fmt.Sprintf("Destination server %s not found!", rq.Destination))
+		return *req, fmt.Errorf("%w: destination server %s not found", ErrInvalidRequest, rq.Destination)
 
 	}
 
@@ -348,21 +371,93 @@ func (rq *RequestForm) Save(db *sqlx.DB) (Request, error) {
 	r.Errors = rq.Extras
 	r.District = rq.District
 	r.Body = rq.Body
+	r.Status = RequestStatusReady
 
 	rows, err := db.NamedQuery(insertRequestSQL, r)
 	if err != nil {
 		log.WithError(err).Error("Error INSERTING Request")
+		return *req, err
 	}
 
+	inserted := false
 	for rows.Next() {
 		var reqId sql.NullInt64
 		_ = rows.Scan(&reqId)
 		r.ID = RequestID(reqId.Int64)
+		inserted = true
 	}
 	_ = rows.Close()
+	if !inserted && len(r.SubmissionID) > 0 {
+		return resubmit(db, req)
+	}
 	return *req, nil
 }
 
+// ErrInvalidRequest is returned for requests that can't be queued as they were sent
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrDuplicateSubmission is returned when a source resends a submission it already queued
+// and the idempotency policy rejects repeats
+var ErrDuplicateSubmission = errors.New("submission already queued")
+
+// constants for the idempotency policies applied to repeat submissions
+const (
+	IdempotencyReturnExisting = "return_existing" // the original request is returned
+	IdempotencyReject         = "reject"          // the repeat is rejected
+	IdempotencyReplacePending = "replace_pending" // the repeat replaces the original unless it is being or has been sent
+)
+
+const replacePendingRequestSQL = `
+UPDATE requests SET (destination, depends_on, batchid, content_type, body, body_is_query_param, period, week, month,
+			year, raw_msg, msisdn, facility, district, report_type, object_type, extras, url_suffix, cc_servers)
+	= (:destination, :depends_on, :batchid, :ctype, :body, :body_is_query_param, :period, :week, :month,
+			:year, :raw_msg, :msisdn, :facility, :district, :report_type, :object_type, :extras, :url_suffix, :cc_servers),
+	status = 'ready', statuscode = '', errors = '', failure_class = '', retries = 0, next_attempt_at = NULL,
+	updated = now()
+	WHERE source = :source AND submissionid = :submissionid AND status IN ('ready', 'failed') AND locked_by IS NULL
+	RETURNING id, uid, source, destination, body, status, raw_msg, period`
+
+// resubmit applies the configured idempotency policy to a repeat of a source's submission
+// and returns the request now queued for it
+func resubmit(db *sqlx.DB, repeat *Request) (Request, error) {
+	logger := log.WithFields(log.Fields{"source": repeat.r.Source, "submissionID": repeat.r.SubmissionID})
+	policy := config.Dispatcher2Conf.Server.IdempotencyPolicy
+	if policy == IdempotencyReplacePending {
+		rows, err := db.NamedQuery(replacePendingRequestSQL, &repeat.r)
+		if err != nil {
+			logger.WithError(err).Error("Failed to replace pending request")
+			return *repeat, err
+		}
+		replaced := Request{}
+		found := rows.Next()
+		if found {
+			err = rows.StructScan(&replaced.r)
+		}
+		_ = rows.Close()
+		if err != nil {
+			return *repeat, err
+		}
+		if found {
+			logger.WithField("uid", replaced.r.UID).Info("Repeat submission replaced pending request")
+			return replaced, nil
+		}
+	}
+
+	existing := Request{}
+	err := db.Get(&existing.r, `
+	SELECT id, uid, source, destination, body, status, raw_msg, period
+	FROM requests WHERE source = $1 AND submissionid = $2`, repeat.r.Source, repeat.r.SubmissionID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get request of repeat submission")
+		return *repeat, err
+	}
+	logger.WithField("uid", existing.r.UID).Info("Repeat submission matched queued request")
+	if policy == IdempotencyReject {
+		return existing, ErrDuplicateSubmission
+	}
+	return existing, nil
+}
+
 func ClearBatchRequests(batch string) {
 	db := db2.GetDB()
 	log.WithField("BatchID", batch).Info("Clearing batch requests")
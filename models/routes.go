@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Route holds the transforms applied to the bodies of requests sent from a source to a destination server
+type Route struct {
+	ID          int64      `db:"id" json:"id,omitempty"`
+	Name        string     `db:"name" json:"name"`
+	Source      int64      `db:"source" json:"source"`
+	Destination int64      `db:"destination" json:"destination"`
+	Transforms  Transforms `db:"transforms" json:"transforms"`
+	IsActive    bool       `db:"is_active" json:"isActive"`
+	Created     time.Time  `db:"created" json:"created,omitempty"`
+	Updated     time.Time  `db:"updated" json:"updated,omitempty"`
+}
+
+// Validate checks that the route's servers are set and its transforms are valid
+func (rt *Route) Validate() error {
+	if rt.Source <= 0 || rt.Destination <= 0 {
+		return errors.New("route needs a source and a destination")
+	}
+	return rt.Transforms.Validate()
+}
+
+// IsJSONContentType returns whether contentType is JSON, e.g. application/json or application/fhir+json
+func IsJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// AppliesTo returns whether the route's transforms can reshape a body of contentType. Transforms only apply
+// to JSON bodies, so bodies of other types and bodies sent as query parameters pass through unchanged
+func (rt *Route) AppliesTo(contentType string, bodyIsQueryParams bool) bool {
+	return len(rt.Transforms) > 0 && !bodyIsQueryParams && IsJSONContentType(contentType)
+}
+
+// CreateRoute inserts a new route into the database
+func CreateRoute(db *sqlx.DB, route Route) (int64, error) {
+	var id int64
+	rows, err := db.NamedQuery(`INSERT INTO routes (name, source, destination, transforms, is_active, created, updated)
+	VALUES (:name, :source, :destination, :transforms, :is_active, now(), now()) RETURNING id`, &route)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+	if rows.Next() {
+		err = rows.Scan(&id)
+	} else {
+		// errors executing the insert, e.g. a duplicate source and destination, come after the query is described
+		err = rows.Err()
+	}
+	return id, err
+}
+
+// ListRoutes returns all routes
+func ListRoutes(db *sqlx.DB) ([]Route, error) {
+	routes := []Route{}
+	err := db.Select(&routes, `SELECT * FROM routes ORDER BY source, destination`)
+	return routes, err
+}
+
+// GetRoute retrieves a route from the database by ID
+func GetRoute(db *sqlx.DB, id int64) (Route, error) {
+	var route Route
+	err := db.Get(&route, `SELECT * FROM routes WHERE id = $1`, id)
+	return route, err
+}
+
+// UpdateRoute updates an existing route in the database
+func UpdateRoute(db *sqlx.DB, route Route) error {
+	_, err := db.NamedExec(`UPDATE routes SET name = :name, source = :source, destination = :destination,
+		transforms = :transforms, is_active = :is_active, updated = now()
+	WHERE id = :id`, &route)
+	return err
+}
+
+// DeleteRoute deletes a route from the database by ID
+func DeleteRoute(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM routes WHERE id = $1`, id)
+	return err
+}
+
+// ActiveRoute returns the active route from source to destination, or nil if there is none
+func ActiveRoute(q sqlx.Queryer, source, destination int64) (*Route, error) {
+	var route Route
+	err := sqlx.Get(q, &route, `SELECT * FROM routes WHERE source = $1 AND destination = $2 AND is_active`,
+		source, destination)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/utils/dbutils"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// constants for the outcome of a schedule run
+const (
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+	ScheduleRunPending   = "pending"
+	ScheduleRunExpired   = "expired"
+	ScheduleRunSkipped   = "skipped"
+)
+
+// responseExcerptLimit is the most of a response kept on a schedule run
+const responseExcerptLimit = 2048
+
+// ScheduleRun is the record of a single run of a schedule
+type ScheduleRun struct {
+	ID         int64     `db:"id" json:"id"`
+	ScheduleID int64     `db:"schedule_id" json:"scheduleID"`
+	Started    time.Time `db:"started" json:"started"`
+	Finished   time.Time `db:"finished" json:"finished"`
+	Outcome    string    `db:"outcome" json:"outcome"`
+	Response   string    `db:"response" json:"response,omitempty"`
+	Errors     string    `db:"errors" json:"errors,omitempty"`
+	ExitCode   *int      `db:"exit_code" json:"exitCode,omitempty"`
+	Stdout     string    `db:"stdout" json:"stdout,omitempty"`
+	Stderr     string    `db:"stderr" json:"stderr,omitempty"`
+	Created    time.Time `db:"created" json:"created"`
+}
+
+// NewScheduleRun starts the run of a schedule
+func NewScheduleRun(scheduleID int64) ScheduleRun {
+	return ScheduleRun{ScheduleID: scheduleID, Started: time.Now().In(Location)}
+}
+
+// Finish sets the outcome of the run keeping only an excerpt of the response
+func (r *ScheduleRun) Finish(outcome, response, errors string) {
+	r.Outcome = outcome
+	r.Response = excerptText(response, responseExcerptLimit)
+	r.Errors = excerptText(errors, responseExcerptLimit)
+	r.Finished = time.Now().In(Location)
+}
+
+// excerptText returns at most limit bytes of s as text Postgres accepts. It is cut on a character boundary,
+// and invalid UTF-8 and NUL bytes, which may be in responses and command output, are dropped
+func excerptText(s string, limit int) string {
+	if len(s) > limit {
+		for limit > 0 && !utf8.RuneStart(s[limit]) {
+			limit--
+		}
+		s = s[:limit]
+	}
+	return strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\x00", "")
+}
+
+const createScheduleRunSQL = `INSERT INTO
+	schedule_runs (schedule_id, started, finished, outcome, response, errors, exit_code, stdout, stderr)
+	VALUES (:schedule_id, :started, :finished, :outcome, :response, :errors, :exit_code, :stdout, :stderr)`
+
+// CreateScheduleRunTx saves the run of a schedule in a transaction. The run is saved under a savepoint so
+// that failing to save it leaves the rest of the transaction, e.g. the schedule's next run, to be committed
+func CreateScheduleRunTx(tx *sqlx.Tx, run ScheduleRun) error {
+	if _, err := tx.Exec(`SAVEPOINT schedule_run`); err != nil {
+		return err
+	}
+	_, err := tx.NamedExec(createScheduleRunSQL, &run)
+	if err != nil {
+		log.WithError(err).WithField("scheduleID", run.ScheduleID).Error("Failed to create schedule run")
+		_, _ = tx.Exec(`ROLLBACK TO SAVEPOINT schedule_run`)
+		return err
+	}
+	_, err = tx.Exec(`RELEASE SAVEPOINT schedule_run`)
+	return err
+}
+
+// GetScheduleRuns returns a page of the runs of a schedule, most recent first
+func GetScheduleRuns(db *sqlx.DB, scheduleID int64, page, pageSize string) (dbutils.Paginator, []ScheduleRun, error) {
+	var count int64
+	err := db.Get(&count, `SELECT COUNT(*) FROM schedule_runs WHERE schedule_id = $1`, scheduleID)
+	if err != nil {
+		return dbutils.Paginator{}, nil, err
+	}
+	pager := dbutils.GetPaginator(count, pageSize, page, true)
+
+	runs := []ScheduleRun{}
+	err = db.Select(&runs, `SELECT * FROM schedule_runs WHERE schedule_id = $1
+		ORDER BY started DESC, id DESC LIMIT $2 OFFSET $3`, scheduleID, pager.PageSize, pager.Offset)
+	if err != nil {
+		return pager, nil, err
+	}
+	return pager, runs, nil
+}
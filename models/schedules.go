@@ -4,10 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/go-resty/resty/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
 	"go-dispatcher2/config"
+	"strings"
 	"time"
 )
 
@@ -61,6 +63,8 @@ type Schedule struct {
 	LastRunAt       NullTime        `db:"last_run_at" json:"lastRunAt,omitempty"` // Use pointer for nullable fields
 	NextRunAt       time.Time       `db:"next_run_at" json:"nextRunAt,omitempty"`
 	Status          string          `db:"status" json:"status,omitempty"`
+	StatusCode      string          `db:"statuscode" json:"statusCode,omitempty"`
+	Response        string          `db:"response" json:"response,omitempty"`
 	IsActive        bool            `db:"is_active" json:"isActive,omitempty"`
 	RequestID       *RequestID      `db:"request_id" json:"request_id,omitempty"`
 	ServerID        *ServerID       `db:"server_id" json:"serverID,omitempty"`
@@ -74,19 +78,30 @@ type Schedule struct {
 
 const createScheduleSQL = `INSERT INTO 
 	schedules (sched_type, params, sched_url, sched_content, command, command_args,
-		repeat, repeat_interval, cron_expression, next_run_at, status, is_active,
+		repeat, repeat_interval, cron_expression, first_run_at, next_run_at, status, is_active,
 		async_job_type, async_jobid, request_id, server_id, server_in_cc,
 		created_by, created, updated) 
 	VALUES (
 		:sched_type, :params, :sched_url, :sched_content, :command, :command_args,
-		:repeat, :repeat_interval, :cron_expression, :next_run_at, :status, :is_active,
+		:repeat, :repeat_interval, :cron_expression, :first_run_at, :next_run_at, :status, :is_active,
 		:async_job_type, :async_jobid, :request_id, :server_id, :server_in_cc,
 		:created_by, :created, :updated
 	) RETURNING id`
 
+// setRunDefaults makes a schedule without a next run due now and counts its repeats from the first run
+func (s *Schedule) setRunDefaults() {
+	if s.NextRunAt.IsZero() {
+		s.NextRunAt = time.Now().In(Location)
+	}
+	if !s.FirstRunAt.Valid || s.FirstRunAt.Time.IsZero() {
+		s.FirstRunAt = NullTime{sql.NullTime{Time: s.NextRunAt, Valid: true}}
+	}
+}
+
 // CreateSchedule inserts a new schedule into the database
 func CreateSchedule(db *sqlx.DB, schedule Schedule) (int64, error) {
 	var id int64
+	schedule.setRunDefaults()
 	res, err := db.NamedExec(createScheduleSQL, &schedule)
 	if err != nil {
 		log.WithFields(
@@ -101,6 +116,7 @@ func CreateSchedule(db *sqlx.DB, schedule Schedule) (int64, error) {
 // CreateScheduleTx creates a new schedule in a transaction
 func CreateScheduleTx(tx *sqlx.Tx, schedule Schedule) (int64, error) {
 	var id int64
+	schedule.setRunDefaults()
 	res, err := tx.NamedExec(createScheduleSQL, &schedule)
 	if err != nil {
 		log.WithFields(
@@ -195,6 +211,68 @@ func (s *Schedule) UpdateRunDetails(tx *sqlx.Tx, status string, nextRun time.Tim
 	return err
 }
 
+// SetResponse sets the status code and response from the last run of the schedule
+func (s *Schedule) SetResponse(tx *sqlx.Tx, statusCode, response string) error {
+	s.StatusCode = statusCode
+	s.Response = response
+	_, err := tx.NamedExec(`UPDATE schedules SET statuscode = :statuscode, response = :response WHERE id = :id`, s)
+	return err
+}
+
+// URLScheduleParams are the HTTP settings read from the params of a url schedule
+type URLScheduleParams struct {
+	Method      string            `json:"method,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	AuthMethod  string            `json:"authMethod,omitempty"` // Basic or Token
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	AuthToken   string            `json:"authToken,omitempty"`
+}
+
+// URLParams returns the HTTP settings of a url schedule
+func (s *Schedule) URLParams() (URLScheduleParams, error) {
+	var params URLScheduleParams
+	if len(s.Params) > 0 {
+		if err := json.Unmarshal(s.Params, &params); err != nil {
+			return params, err
+		}
+	}
+	if params.Method == "" {
+		params.Method = "GET"
+		if len(s.ScheduleContent) > 0 {
+			params.Method = "POST"
+		}
+	}
+	if params.ContentType == "" {
+		params.ContentType = "application/json"
+	}
+	return params, nil
+}
+
+// CallURL calls the schedule URL with the schedule content as the body
+func (s *Schedule) CallURL() (*resty.Response, error) {
+	params, err := s.URLParams()
+	if err != nil {
+		return nil, fmt.Errorf("invalid params for url schedule %d: %w", s.ID, err)
+	}
+	request := resty.New().R().
+		SetHeader("Content-Type", params.ContentType).
+		SetHeader("User-Agent", "Dispatcher2-Go").
+		SetHeaders(params.Headers)
+
+	switch params.AuthMethod {
+	case "Basic":
+		request.SetBasicAuth(params.Username, params.Password)
+	case "Token":
+		request.SetHeader("Authorization", "ApiToken "+params.AuthToken)
+	}
+	if len(s.ScheduleContent) > 0 {
+		request.SetBody(s.ScheduleContent)
+	}
+	return request.Execute(strings.ToUpper(params.Method), s.ScheduleURL)
+}
+
 // DeleteSchedule deletes a schedule from the database by ID
 func DeleteSchedule(db *sqlx.DB, id int64) error {
 	query := `DELETE FROM schedules WHERE id = $1`
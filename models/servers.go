@@ -99,8 +99,23 @@ type Server struct {
 		EndOfSubmissionPeriod   int                 `db:"end_submission_period" json:"endSubmissionPeriod"`
 		XMLResponseXPATH        string              `db:"xml_response_xpath"  json:"XMLResponseXPATH"`
 		JSONResponseXPATH       string              `db:"json_response_xpath" json:"JSONResponseXPATH"`
+		ResponseFailureValues   pq.StringArray      `db:"response_failure_values" json:"responseFailureValues,omitempty"` // values at the response path marking failure
 		Suspended               bool                `db:"suspended" json:"suspended,omitempty"`
 		URLParams               dbutils.MapAnything `db:"url_params" json:"URLParams,omitempty"`
+		RetryBackoffBase        int                 `db:"retry_backoff_base" json:"retryBackoffBase,omitempty"`     // seconds before the first retry of a failed request
+		RetryBackoffMax         int                 `db:"retry_backoff_max" json:"retryBackoffMax,omitempty"`       // most seconds between retries
+		RetryBackoffJitter      *float64            `db:"retry_backoff_jitter" json:"retryBackoffJitter,omitempty"` // fraction of each wait that is random, unset for 0.2
+		RateLimit               float64             `db:"rate_limit" json:"rateLimit,omitempty"`                    // most requests sent per second, 0 for no limit
+		MaxInFlight             int                 `db:"max_in_flight" json:"maxInFlight,omitempty"`               // most requests sent at once, 0 for no limit
+		InsecureSkipVerify      bool                `db:"insecure_skip_verify" json:"insecureSkipVerify,omitempty"` // don't verify the server's certificate
+		ConnectTimeout          int                 `db:"connect_timeout" json:"connectTimeout,omitempty"`          // seconds, 0 for the global default
+		ReadTimeout             int                 `db:"read_timeout" json:"readTimeout,omitempty"`                // seconds, 0 for the global default
+		OAuth2TokenURL          string              `db:"oauth2_token_url" json:"oauth2TokenURL,omitempty"`         // where OAuth2 client credentials are exchanged for tokens
+		OAuth2ClientID          string              `db:"oauth2_client_id" json:"oauth2ClientID,omitempty"`
+		OAuth2ClientSecret      string              `db:"oauth2_client_secret" json:"oauth2ClientSecret,omitempty"`
+		OAuth2Scopes            string              `db:"oauth2_scopes" json:"oauth2Scopes,omitempty"`  // space separated
+		APIKeyHeader            string              `db:"api_key_header" json:"apiKeyHeader,omitempty"` // header carrying the AuthToken as an API key
+		HMACHeader              string              `db:"hmac_header" json:"hmacHeader,omitempty"`      // header carrying the signature keyed with the AuthToken
 		Created                 time.Time           `db:"created" json:"created,omitempty"`
 		Updated                 time.Time           `db:"updated" json:"updated,omitempty"`
 		AllowedSources          []string            `json:"allowedSources,omitempty"`
@@ -153,6 +168,43 @@ func (s *Server) HTTPMethod() string { return s.s.HTTPMethod }
 // AuthMethod ...
 func (s *Server) AuthMethod() string { return s.s.AuthMethod }
 
+// RetryBackoff returns how long to wait before retrying a request that has failed attempts times on the server
+func (s *Server) RetryBackoff(attempts int) time.Duration {
+	base, max, jitter := s.s.RetryBackoffBase, s.s.RetryBackoffMax, 0.2
+	if base <= 0 {
+		base = 60
+	}
+	if max <= 0 {
+		max = 3600
+	}
+	// a jitter of 0 turns it off, so only an unset or out of range jitter falls back to the default
+	if j := s.s.RetryBackoffJitter; j != nil && *j >= 0 && *j <= 1 {
+		jitter = *j
+	}
+	return ExponentialBackoff(time.Duration(base)*time.Second, time.Duration(max)*time.Second, jitter, attempts)
+}
+
+// RateLimit returns the most requests sent to the server per second, 0 for no limit
+func (s *Server) RateLimit() float64 { return s.s.RateLimit }
+
+// MaxInFlight returns the most requests sent to the server at once, 0 for no limit
+func (s *Server) MaxInFlight() int { return s.s.MaxInFlight }
+
+// UseSSL returns whether the server expects our client certificate
+func (s *Server) UseSSL() bool { return s.s.UseSSL }
+
+// SSLClientCertKeyFile returns the PEM file with the client certificate and key presented to the server
+func (s *Server) SSLClientCertKeyFile() string { return s.s.SSLClientCertKeyFile }
+
+// InsecureSkipVerify returns whether the server's certificate goes unverified
+func (s *Server) InsecureSkipVerify() bool { return s.s.InsecureSkipVerify }
+
+// CCURLs returns the extra URLs that get a copy of every request to the server
+func (s *Server) CCURLs() []string { return s.s.CCURLS }
+
+// AllowCopies returns whether requests to the server are copied to its CC URLs
+func (s *Server) AllowCopies() bool { return s.s.AllowCopies }
+
 // AllowCallbacks returns whether server allows callbacks
 func (s *Server) AllowCallbacks() bool { return s.s.AllowCallbacks }
 
@@ -165,6 +217,15 @@ func (s *Server) CallbackURL() string { return s.s.CallbackURL }
 // ParseResponses return whether we shold parse the server's responses
 func (s *Server) ParseResponses() bool { return s.s.ParseResponses }
 
+// JSONResponseXPATH returns the gjson path to the value judging the server's JSON responses
+func (s *Server) JSONResponseXPATH() string { return s.s.JSONResponseXPATH }
+
+// ResponseFailureValues returns the values at the server's response path that mark a request as failed
+func (s *Server) ResponseFailureValues() []string { return s.s.ResponseFailureValues }
+
+// XMLResponseXPATH returns the XPath to the value judging the server's XML responses
+func (s *Server) XMLResponseXPATH() string { return s.s.XMLResponseXPATH }
+
 // EndOfSubmissionPeriod returns the end of the submission period for the server
 func (s *Server) EndOfSubmissionPeriod() int { return s.s.EndOfSubmissionPeriod }
 
@@ -353,10 +414,16 @@ func GetServers(db *sqlx.DB, page string, pageSize string,
 const insertServerSQL = `
 INSERT INTO servers(uid, name, username, password, url, ipaddress, http_method, auth_method, auth_token,
        callback_url, allow_callbacks, cc_urls, allow_copies, start_submission_period, end_submission_period,
-       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params)
+       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params,
+       retry_backoff_base, retry_backoff_max, retry_backoff_jitter, rate_limit, max_in_flight,
+       insecure_skip_verify, connect_timeout, read_timeout, oauth2_token_url, oauth2_client_id, oauth2_client_secret,
+       oauth2_scopes, api_key_header, hmac_header, response_failure_values)
        VALUES (generate_uid(),:name,:username,:password,:url,:ipaddress,:http_method,:auth_method,:auth_token, :callback_url,:allow_callbacks, 
                :cc_urls,:allow_copies,:start_submission_period,:end_submission_period,:parse_responses,:use_async, :use_ssl,
-               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params)
+               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params,
+               :retry_backoff_base, :retry_backoff_max, :retry_backoff_jitter, :rate_limit, :max_in_flight,
+               :insecure_skip_verify, :connect_timeout, :read_timeout, :oauth2_token_url, :oauth2_client_id, :oauth2_client_secret,
+               :oauth2_scopes, :api_key_header, :hmac_header, :response_failure_values)
 	RETURNING id
 `
 
@@ -463,10 +530,16 @@ func CreateServerFromJSON(db *sqlx.DB, serverJSON []byte) (Server, error) {
 const updateServerSQL = `
 UPDATE servers SET (name, username, password, url, ipaddress, http_method,auth_method, auth_token,
        callback_url, allow_callbacks, cc_urls, allow_copies, start_submission_period, end_submission_period,
-       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params)
+       parse_responses, use_async, use_ssl, suspended, ssl_client_certkey_file, json_response_xpath, xml_response_xpath, endpoint_type, url_params,
+       retry_backoff_base, retry_backoff_max, retry_backoff_jitter, rate_limit, max_in_flight,
+       insecure_skip_verify, connect_timeout, read_timeout, oauth2_token_url, oauth2_client_id, oauth2_client_secret,
+       oauth2_scopes, api_key_header, hmac_header, response_failure_values)
 	= (:name,:username,:password,:url,:ipaddress,:http_method,:auth_method,:auth_token, :callback_url,:allow_callbacks, 
                :cc_urls,:allow_copies,:start_submission_period,:end_submission_period,:parse_responses, :use_async, :use_ssl,
-               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params)
+               :suspended,:ssl_client_certkey_file,:json_response_xpath,:xml_response_xpath, :endpoint_type, :url_params,
+               :retry_backoff_base, :retry_backoff_max, :retry_backoff_jitter, :rate_limit, :max_in_flight,
+               :insecure_skip_verify, :connect_timeout, :read_timeout, :oauth2_token_url, :oauth2_client_id, :oauth2_client_secret,
+               :oauth2_scopes, :api_key_header, :hmac_header, :response_failure_values)
 	WHERE uid = :uid
 `
 
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"strings"
+)
+
+// SMSReportValue is a value of an SMS report and the mapping of its position in the report
+type SMSReportValue struct {
+	Mapping Dhis2IndicatorMapping
+	Value   string
+}
+
+// SMSReport is an SMS keyword report, e.g. "MAL.12.3.4", split into the values of its keyword's mappings
+type SMSReport struct {
+	Keyword string
+	DataSet string // the dataset all the keyword's mappings belong to
+	Values  []SMSReportValue
+}
+
+// SMSReportError is a problem with an SMS report. Its message is the reply sent back to the reporter
+type SMSReportError struct {
+	Reply string
+}
+
+func (e *SMSReportError) Error() string { return e.Reply }
+
+// smsReportError returns an SMSReportError with the formatted reply
+func smsReportError(format string, args ...interface{}) error {
+	return &SMSReportError{Reply: fmt.Sprintf(format, args...)}
+}
+
+// IsBlacklisted returns whether reports from the msisdn are refused
+func IsBlacklisted(db *sqlx.DB, msisdn string) (bool, error) {
+	var blacklisted bool
+	err := db.Get(&blacklisted, `SELECT EXISTS(SELECT 1 FROM blacklist WHERE msisdn = $1)`, msisdn)
+	return blacklisted, err
+}
+
+// ParseSMSReport splits a report made of its keyword and values separated by dots into the values of the
+// indicator mappings whose cmd is the keyword, in their form order. Every value must be a whole number.
+// Problems with the report are returned as an SMSReportError
+func ParseSMSReport(db *sqlx.DB, message string) (SMSReport, error) {
+	return parseSMSReport(message, func(keyword string) ([]Dhis2IndicatorMapping, error) {
+		return GetKeywordIndicatorMappings(db, keyword)
+	})
+}
+
+// parseSMSReport parses an SMS report with the mappings of its keyword given by keywordMappings.
+// A keyword whose mappings span several datasets can't be reported as one dataValueSet and is an error
+func parseSMSReport(
+	message string, keywordMappings func(keyword string) ([]Dhis2IndicatorMapping, error),
+) (SMSReport, error) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(message), "."), ".")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	keyword := strings.ToUpper(parts[0])
+	if len(keyword) == 0 {
+		return SMSReport{}, smsReportError("Empty report. Send the report keyword followed by its values, e.g. MAL.12.3.4")
+	}
+	mappings, err := keywordMappings(keyword)
+	if err != nil {
+		return SMSReport{}, err
+	}
+	if len(mappings) == 0 {
+		return SMSReport{}, smsReportError("Unknown report %s", keyword)
+	}
+	for _, mapping := range mappings[1:] {
+		if mapping.DataSet != mappings[0].DataSet {
+			return SMSReport{}, fmt.Errorf("report %s is mapped to more than one dataset: %s and %s",
+				keyword, mappings[0].DataSet, mapping.DataSet)
+		}
+	}
+	values := parts[1:]
+	if len(values) != len(mappings) {
+		return SMSReport{}, smsReportError("%s report expects %d values but %d were sent", keyword, len(mappings), len(values))
+	}
+
+	report := SMSReport{Keyword: keyword, DataSet: mappings[0].DataSet}
+	var invalid []string
+	for i, value := range values {
+		name := mappings[i].Name
+		if len(name) == 0 {
+			name = mappings[i].Slug
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			invalid = append(invalid, fmt.Sprintf("%s '%s'", name, value))
+			continue
+		}
+		report.Values = append(report.Values, SMSReportValue{Mapping: mappings[i], Value: value})
+	}
+	if len(invalid) > 0 {
+		return SMSReport{}, smsReportError("%s report has invalid values for %s. Values must be whole numbers",
+			keyword, strings.Join(invalid, ", "))
+	}
+	return report, nil
+}
+
+// IsSMSReportError returns whether err is a problem with an SMS report rather than one processing it
+func IsSMSReportError(err error) bool {
+	var reportErr *SMSReportError
+	return errors.As(err, &reportErr)
+}
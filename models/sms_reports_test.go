@@ -0,0 +1,121 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSMSReport(t *testing.T) {
+	mal := []Dhis2IndicatorMapping{
+		{Name: "Cases", Slug: "cases", Cmd: "mal", DataElement: "de1", DataSet: "ds1"},
+		{Name: "Deaths", Slug: "deaths", Cmd: "mal", DataElement: "de2", DataSet: "ds1"},
+		{Slug: "tested", Cmd: "mal", DataElement: "de3", DataSet: "ds1"},
+	}
+	split := []Dhis2IndicatorMapping{
+		{Slug: "a", Cmd: "split", DataElement: "de1", DataSet: "ds1"},
+		{Slug: "b", Cmd: "split", DataElement: "de2", DataSet: "ds2"},
+	}
+	lookup := func(keyword string) ([]Dhis2IndicatorMapping, error) {
+		switch keyword {
+		case "MAL":
+			return mal, nil
+		case "SPLIT":
+			return split, nil
+		case "BROKEN":
+			return nil, errors.New("database is down")
+		}
+		return nil, nil
+	}
+
+	tcs := []struct {
+		name        string
+		message     string
+		keyword     string
+		values      []string
+		reply       string
+		hasError    bool
+		isReportErr bool
+	}{
+		{
+			name:    "report",
+			message: "MAL.12.3.4",
+			keyword: "MAL",
+			values:  []string{"12", "3", "4"},
+		},
+		{
+			name:    "lower case keyword, spaces and trailing dot",
+			message: " mal. 12 .3.4. ",
+			keyword: "MAL",
+			values:  []string{"12", "3", "4"},
+		},
+		{
+			name:        "empty report",
+			message:     "  ",
+			reply:       "Empty report. Send the report keyword followed by its values, e.g. MAL.12.3.4",
+			hasError:    true,
+			isReportErr: true,
+		},
+		{
+			name:        "unknown keyword",
+			message:     "TB.1.2",
+			reply:       "Unknown report TB",
+			hasError:    true,
+			isReportErr: true,
+		},
+		{
+			name:        "too few values",
+			message:     "MAL.12.3",
+			reply:       "MAL report expects 3 values but 2 were sent",
+			hasError:    true,
+			isReportErr: true,
+		},
+		{
+			name:        "too many values",
+			message:     "MAL.12.3.4.5",
+			reply:       "MAL report expects 3 values but 4 were sent",
+			hasError:    true,
+			isReportErr: true,
+		},
+		{
+			name:        "values that aren't whole numbers",
+			message:     "MAL.12.x.-4",
+			reply:       "MAL report has invalid values for Deaths 'x', tested '-4'. Values must be whole numbers",
+			hasError:    true,
+			isReportErr: true,
+		},
+		{
+			name:     "keyword mapped to several datasets",
+			message:  "SPLIT.1.2",
+			hasError: true,
+		},
+		{
+			name:     "mappings can't be looked up",
+			message:  "BROKEN.1",
+			hasError: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := parseSMSReport(tc.message, lookup)
+			if tc.hasError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.isReportErr, IsSMSReportError(err))
+				if tc.isReportErr {
+					assert.Equal(t, tc.reply, err.Error())
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.keyword, report.Keyword)
+			assert.Equal(t, "ds1", report.DataSet)
+			var values []string
+			for i, v := range report.Values {
+				assert.Equal(t, mal[i].DataElement, v.Mapping.DataElement)
+				values = append(values, v.Value)
+			}
+			assert.Equal(t, tc.values, values)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+// throttleRetryWait is how long a request held back by a server's in-flight cap waits before it is tried again
+const throttleRetryWait = time.Second
+
+// serverThrottle keeps the requests sent to a server within its rate limit and cap on requests in flight
+type serverThrottle struct {
+	mu        sync.Mutex
+	limiter   *rate.Limiter
+	rateLimit float64
+	inFlight  int
+}
+
+var (
+	serverThrottles   = make(map[ServerID]*serverThrottle)
+	serverThrottlesMu sync.Mutex
+)
+
+// getServerThrottle returns the throttle of the server with serverID
+func getServerThrottle(serverID ServerID) *serverThrottle {
+	serverThrottlesMu.Lock()
+	defer serverThrottlesMu.Unlock()
+	throttle, ok := serverThrottles[serverID]
+	if !ok {
+		throttle = &serverThrottle{}
+		serverThrottles[serverID] = throttle
+	}
+	return throttle
+}
+
+// AcquireSendSlot returns whether a request may be sent to the server now without going over its rate limit
+// or its cap on requests in flight. When it may not, the time after which it may be tried again is returned.
+// Every slot acquired must be given back with ReleaseSendSlot once the request is done.
+func AcquireSendSlot(server Server) (bool, time.Time) {
+	throttle := getServerThrottle(server.ID())
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+
+	now := time.Now()
+	if server.MaxInFlight() > 0 && throttle.inFlight >= server.MaxInFlight() {
+		return false, now.Add(throttleRetryWait)
+	}
+	if server.RateLimit() > 0 {
+		if throttle.limiter == nil || throttle.rateLimit != server.RateLimit() { // new or reloaded server
+			burst := int(math.Max(1, math.Floor(server.RateLimit())))
+			throttle.limiter = rate.NewLimiter(rate.Limit(server.RateLimit()), burst)
+			throttle.rateLimit = server.RateLimit()
+		}
+		reservation := throttle.limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return false, now.Add(delay)
+		}
+	}
+	throttle.inFlight += 1
+	return true, time.Time{}
+}
+
+// ReleaseSendSlot gives back a slot acquired with AcquireSendSlot
+func ReleaseSendSlot(server Server) {
+	throttle := getServerThrottle(server.ID())
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+	if throttle.inFlight > 0 {
+		throttle.inFlight -= 1
+	}
+}
@@ -0,0 +1,262 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// constants for the types of transform
+const (
+	TransformTemplate = "template" // renders a new body from a Go text/template given the body
+	TransformRename   = "rename"   // moves the value at From to To
+	TransformConstant = "constant" // sets the value at Path to Value
+	TransformDrop     = "drop"     // removes the value at Path
+)
+
+// Transform is a step in reshaping a JSON body. Paths are dot separated keys of objects
+// or indexes of arrays, e.g. "results.age.value" or "dataValues.0.value"
+type Transform struct {
+	Type     string      `json:"type"`
+	Template string      `json:"template,omitempty"`
+	From     string      `json:"from,omitempty"`
+	To       string      `json:"to,omitempty"`
+	Path     string      `json:"path,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// Transforms are applied to a body in order
+type Transforms []Transform
+
+// Scan implements the sql.Scanner interface
+func (t *Transforms) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*t = Transforms{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into transforms", src)
+	}
+	return json.Unmarshal(data, t)
+}
+
+// Value implements the driver.Valuer interface
+func (t Transforms) Value() (driver.Value, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(t)
+}
+
+// templateFuncs are the functions available to template transforms
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Validate checks that every transform is complete and its template parses
+func (t Transforms) Validate() error {
+	for i, transform := range t {
+		var err error
+		switch transform.Type {
+		case TransformTemplate:
+			_, err = template.New("transform").Funcs(templateFuncs).Parse(transform.Template)
+		case TransformRename:
+			if len(transform.From) == 0 || len(transform.To) == 0 {
+				err = errors.New("rename needs from and to")
+			}
+		case TransformConstant, TransformDrop:
+			if len(transform.Path) == 0 {
+				err = fmt.Errorf("%s needs a path", transform.Type)
+			}
+		default:
+			err = fmt.Errorf("unknown type '%s'", transform.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("transform %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Apply returns the JSON body reshaped by the transforms
+func (t Transforms) Apply(body string) (string, error) {
+	if len(t) == 0 {
+		return body, nil
+	}
+	data, err := decodeJSONBody([]byte(body))
+	if err != nil {
+		return "", fmt.Errorf("transforms apply to JSON bodies only: %w", err)
+	}
+	for i, transform := range t {
+		if data, err = transform.apply(data); err != nil {
+			return "", fmt.Errorf("transform %d (%s): %w", i+1, transform.Type, err)
+		}
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// apply returns data after the transform
+func (t Transform) apply(data interface{}) (interface{}, error) {
+	switch t.Type {
+	case TransformTemplate:
+		tmpl, err := template.New("transform").Funcs(templateFuncs).Parse(t.Template)
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		rendered, err := decodeJSONBody(out.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("template did not render JSON: %w", err)
+		}
+		return rendered, nil
+	case TransformRename:
+		value, ok := getPath(data, t.From)
+		if !ok {
+			return data, nil
+		}
+		data, _ = deletePath(data, t.From)
+		return setPath(data, t.To, value)
+	case TransformConstant:
+		return setPath(data, t.Path, t.Value)
+	case TransformDrop:
+		data, _ = deletePath(data, t.Path)
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", t.Type)
+	}
+}
+
+// decodeJSONBody decodes a JSON body keeping numbers as they were sent
+func decodeJSONBody(body []byte) (interface{}, error) {
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// getPath returns the value at path in data
+func getPath(data interface{}, path string) (interface{}, bool) {
+	current := data
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath sets the value at path in data, creating the objects on the way, and returns the updated data
+func setPath(data interface{}, path string, value interface{}) (interface{}, error) {
+	key, rest, nested := strings.Cut(path, ".")
+	switch node := data.(type) {
+	case nil:
+		if !nested {
+			return map[string]interface{}{key: value}, nil
+		}
+		child, err := setPath(nil, rest, value)
+		return map[string]interface{}{key: child}, err
+	case map[string]interface{}:
+		if !nested {
+			node[key] = value
+			return node, nil
+		}
+		child, err := setPath(node[key], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[key] = child
+		return node, nil
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, fmt.Errorf("no element '%s' in array at '%s'", key, path)
+		}
+		if !nested {
+			node[i] = value
+			return node, nil
+		}
+		child, err := setPath(node[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, fmt.Errorf("cannot set '%s' in a %T", path, data)
+	}
+}
+
+// deletePath removes the value at path in data and returns the updated data and whether there was a value
+func deletePath(data interface{}, path string) (interface{}, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	switch node := data.(type) {
+	case map[string]interface{}:
+		if _, ok := node[key]; !ok {
+			return node, false
+		}
+		if !nested {
+			delete(node, key)
+			return node, true
+		}
+		child, deleted := deletePath(node[key], rest)
+		node[key] = child
+		return node, deleted
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(node) {
+			return node, false
+		}
+		if !nested {
+			return append(node[:i], node[i+1:]...), true
+		}
+		child, deleted := deletePath(node[i], rest)
+		node[i] = child
+		return node, deleted
+	default:
+		return data, false
+	}
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// toJSON returns data encoded as JSON
+func toJSON(t *testing.T, data interface{}) string {
+	out, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestTransformPaths(t *testing.T) {
+	body := func() interface{} {
+		data, err := decodeJSONBody([]byte(`{"a": {"b": 1, "c": [10, {"d": "x"}, 30]}, "e": null}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	getTcs := []struct {
+		name  string
+		path  string
+		value interface{}
+		found bool
+	}{
+		{name: "top level key", path: "e", value: nil, found: true},
+		{name: "nested key", path: "a.b", value: "1", found: true},
+		{name: "array element", path: "a.c.0", value: "10", found: true},
+		{name: "key in array element", path: "a.c.1.d", value: "x", found: true},
+		{name: "missing key", path: "a.z"},
+		{name: "index out of range", path: "a.c.3"},
+		{name: "key in array", path: "a.c.d"},
+		{name: "path through a value", path: "a.b.c"},
+	}
+	for _, tc := range getTcs {
+		t.Run("get "+tc.name, func(t *testing.T) {
+			value, found := getPath(body(), tc.path)
+			assert.Equal(t, tc.found, found)
+			if found && value != nil {
+				assert.Equal(t, tc.value, fmt.Sprint(value))
+			}
+		})
+	}
+
+	setTcs := []struct {
+		name     string
+		data     interface{}
+		path     string
+		expected string
+		hasError bool
+	}{
+		{name: "new top level key", data: body(), path: "f",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null,"f":"v"}`},
+		{name: "replaces nested key", data: body(), path: "a.b",
+			expected: `{"a":{"b":"v","c":[10,{"d":"x"},30]},"e":null}`},
+		{name: "creates nested objects", data: body(), path: "g.h.i",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null,"g":{"h":{"i":"v"}}}`},
+		{name: "creates objects in null", data: body(), path: "e.f",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":{"f":"v"}}`},
+		{name: "array element", data: body(), path: "a.c.2",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},"v"]},"e":null}`},
+		{name: "key in array element", data: body(), path: "a.c.1.d",
+			expected: `{"a":{"b":1,"c":[10,{"d":"v"},30]},"e":null}`},
+		{name: "empty body", data: nil, path: "a.b", expected: `{"a":{"b":"v"}}`},
+		{name: "index out of range", data: body(), path: "a.c.3", hasError: true},
+		{name: "key in array", data: body(), path: "a.c.d", hasError: true},
+		{name: "path through a value", data: body(), path: "a.b.c", hasError: true},
+	}
+	for _, tc := range setTcs {
+		t.Run("set "+tc.name, func(t *testing.T) {
+			data, err := setPath(tc.data, tc.path, "v")
+			if tc.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, toJSON(t, data))
+		})
+	}
+
+	deleteTcs := []struct {
+		name     string
+		path     string
+		expected string
+		deleted  bool
+	}{
+		{name: "top level key", path: "e",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]}}`, deleted: true},
+		{name: "nested key", path: "a.b",
+			expected: `{"a":{"c":[10,{"d":"x"},30]},"e":null}`, deleted: true},
+		{name: "removes first array element", path: "a.c.0",
+			expected: `{"a":{"b":1,"c":[{"d":"x"},30]},"e":null}`, deleted: true},
+		{name: "removes middle array element", path: "a.c.1",
+			expected: `{"a":{"b":1,"c":[10,30]},"e":null}`, deleted: true},
+		{name: "removes last array element", path: "a.c.2",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"}]},"e":null}`, deleted: true},
+		{name: "key in array element", path: "a.c.1.d",
+			expected: `{"a":{"b":1,"c":[10,{},30]},"e":null}`, deleted: true},
+		{name: "missing key", path: "a.z",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null}`},
+		{name: "missing nested key", path: "z.y",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null}`},
+		{name: "index out of range", path: "a.c.3",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null}`},
+		{name: "path through a value", path: "a.b.c",
+			expected: `{"a":{"b":1,"c":[10,{"d":"x"},30]},"e":null}`},
+	}
+	for _, tc := range deleteTcs {
+		t.Run("delete "+tc.name, func(t *testing.T) {
+			data, deleted := deletePath(body(), tc.path)
+			assert.Equal(t, tc.deleted, deleted)
+			assert.JSONEq(t, tc.expected, toJSON(t, data))
+		})
+	}
+}
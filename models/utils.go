@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
+	"net/http"
 	"strings"
 )
 
@@ -21,8 +22,19 @@ type Client struct {
 	// AuthToken  string
 }
 
+// NewClient returns the REST client of the server, built on its pooled HTTP client
 func (s *Server) NewClient() (*Client, error) {
-	client := resty.New()
+	pooled, err := s.pooledClientFor()
+	if err != nil {
+		log.WithError(err).WithField("server", s.ID()).Error("Failed to set up HTTP client")
+		return nil, err
+	}
+	pooledClientsMu.Lock()
+	defer pooledClientsMu.Unlock()
+	if pooled.restClient != nil {
+		return pooled.restClient, nil
+	}
+	client := resty.NewWithClient(pooled.httpClient)
 	baseUrl, err := GetDHIS2BaseURL(s.URL())
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -35,18 +47,22 @@ func (s *Server) NewClient() (*Client, error) {
 		"Content-Type": "application/json",
 		"User-Agent":   "Dispatcher2-Go",
 	})
-	switch s.AuthMethod() {
-	case "Basic":
-		client.SetBasicAuth(s.Username(), s.Password())
-	case "Token":
-		client.SetAuthScheme("Token")
-		client.SetAuthToken(s.AuthToken())
-	}
-	return &Client{
+	authProvider := s.AuthProvider()
+	client.SetPreRequestHook(func(_ *resty.Client, req *http.Request) error {
+		return authProvider.Authorize(req)
+	})
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		if resp.StatusCode() == http.StatusUnauthorized && resp.Request.RawRequest != nil {
+			authProvider.Unauthorized(resp.Request.RawRequest)
+		}
+		return nil
+	})
+	pooled.restClient = &Client{
 		RestClient: client,
 		BaseURL:    baseUrl + "/api",
 		// AuthToken:  s.AuthToken(),
-	}, nil
+	}
+	return pooled.restClient, nil
 }
 
 func (c *Client) GetResource(resourcePath string, params map[string]string) (*resty.Response, error) {
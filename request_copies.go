@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/config"
+	"go-dispatcher2/models"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ccURLKey returns the key under which the status of the copy of a request to ccURL is kept in cc_servers_status
+func ccURLKey(ccURL string) string { return "url:" + ccURL }
+
+// sendCopies sends a copy of the request to each of the destination's CC URLs if the destination allows copies.
+// Copies already delivered, failed permanently or out of retries are not sent again.
+func (r *RequestObject) sendCopies(tx *sqlx.Tx, destination models.Server) {
+	if !destination.AllowCopies() {
+		return
+	}
+	for _, ccURL := range destination.CCURLs() {
+		if len(ccURL) == 0 {
+			continue
+		}
+		if previous, ok := r.CCServersStatus[ccURLKey(ccURL)].(map[string]interface{}); ok {
+			if previous["status"] == string(models.RequestStatusCompleted) ||
+				previous["status"] == string(models.RequestStatusError) {
+				continue
+			}
+			if next, ok := previous["nextAttemptAt"].(string); ok && len(next) > 0 {
+				if nextAttemptAt, err := time.Parse(time.RFC3339, next); err == nil && nextAttemptAt.After(time.Now()) {
+					continue
+				}
+			}
+			retries := 0
+			switch v := previous["retries"].(type) {
+			case float64:
+				retries = int(v)
+			case int:
+				retries = v
+			}
+			if retries > config.Dispatcher2Conf.Server.MaxRetries {
+				continue
+			}
+		}
+		r.sendCopy(tx, destination, ccURL)
+	}
+}
+
+// retryCopies sends the copies of a request already delivered to its destination that are due again, as long as
+// the destination is neither throttled nor held back by its circuit breaker. Copies held back are tried on the
+// next retry run. The destination's send slot and breaker trial are given back before the copies are sent, as
+// copies don't reach the destination and tell the breaker nothing about it
+func (r *RequestObject) retryCopies(tx *sqlx.Tx, destination models.Server) {
+	if !destination.AllowCopies() {
+		return
+	}
+	if allowed, _ := models.AcquireSendSlot(destination); !allowed {
+		return
+	}
+	models.ReleaseSendSlot(destination)
+	breaker := models.GetCircuitBreaker(destination.ID())
+	if allowed, _ := breaker.Allow(); !allowed {
+		return
+	}
+	breaker.Release()
+	r.sendCopies(tx, destination)
+}
+
+// sendCopy sends a copy of the request to ccURL and records its status. The copy is sent as the request
+// would be to the destination but without the destination's parameters and credentials
+func (r *RequestObject) sendCopy(tx *sqlx.Tx, destination models.Server, ccURL string) {
+	key := ccURLKey(ccURL)
+	logger := log.WithFields(log.Fields{"requestID": r.ID, "ccURL": ccURL})
+	var resp *http.Response
+	req, err := newRequest(r, destination.HTTPMethod(), ccURL, nil)
+	if err != nil {
+		logger.WithError(err).Error("Failed to build copy of request")
+		r.setCCStatus(tx, key, destination, ServerStatus{
+			Status: models.RequestStatusError, StatusCode: "ERROR01", Errors: err.Error(), Retries: 1,
+			FailureClass: FailureValidation})
+		return
+	}
+	client, err := models.DefaultHTTPClient()
+	if err == nil {
+		resp, err = client.Do(req.WithContext(r.sendContext()))
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to send copy of request")
+		r.setCCStatus(tx, key, destination, ServerStatus{
+			Status: models.RequestStatusFailed, StatusCode: "ERROR02", Errors: err.Error(), Retries: 1,
+			FailureClass: ClassifyError(err)})
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	result, _ := GenericHTTPAdapter{}.InterpretResponse(resp.StatusCode, nil)
+	status := ServerStatus{StatusCode: fmt.Sprintf("%d", resp.StatusCode), Errors: result.Summary, Retries: 1}
+	if result.Completed {
+		status.Status = models.RequestStatusCompleted
+		logger.Info("Copy of request sent")
+	} else {
+		status.FailureClass = ClassifyStatus(resp.StatusCode)
+		status.RetryAfter = RetryAfter(resp)
+		status.Status = models.RequestStatusFailed
+		if !status.FailureClass.Retryable() {
+			status.Status = models.RequestStatusError
+		}
+		logger.WithField("responseStatus", resp.StatusCode).Warn("A non 200 response to copy of request")
+	}
+	r.setCCStatus(tx, key, destination, status)
+}
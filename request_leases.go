@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"go-dispatcher2/config"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	instanceIDOnce sync.Once
+	instanceIDStr  string
+)
+
+// InstanceID returns the name under which this dispatcher claims requests. It is the configured instance_id
+// or else the host name and process id, so that replicas sharing a database never claim under the same name
+func InstanceID() string {
+	instanceIDOnce.Do(func() {
+		instanceIDStr = config.Dispatcher2Conf.Server.InstanceID
+		if len(instanceIDStr) == 0 {
+			hostname, _ := os.Hostname()
+			instanceIDStr = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+	})
+	return instanceIDStr
+}
+
+// requestLeaseDuration is how long a claim on a request holds before the reaper hands it back to the queue
+func requestLeaseDuration() time.Duration {
+	if config.Dispatcher2Conf.Server.RequestLeaseDuration <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(config.Dispatcher2Conf.Server.RequestLeaseDuration) * time.Second
+}
+
+const claimRequestsSQL = `
+	WITH claimed AS (
+		SELECT id FROM requests
+		WHERE status = 'ready' AND status_of_dependence(id) IN ('completed', '')
+			AND (next_attempt_at IS NULL OR next_attempt_at <= NOW()) %s
+		ORDER BY depends_on desc, created LIMIT $1
+		FOR UPDATE SKIP LOCKED)
+	UPDATE requests r SET status = 'inprogress', locked_by = $2, lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+	FROM claimed WHERE r.id = claimed.id
+	RETURNING r.id`
+
+// ClaimReadyRequests claims up to limit ready requests for this instance, skipping those claimed by others.
+// With a requestID only that request is claimed if it is ready.
+func ClaimReadyRequests(db *sqlx.DB, limit int, requestID int) ([]int, error) {
+	args := []interface{}{limit, InstanceID(), int(requestLeaseDuration().Seconds())}
+	condition := ""
+	if requestID > 0 {
+		condition = "AND id = $4"
+		args = append(args, requestID)
+	}
+	var requestIDs []int
+	err := db.Select(&requestIDs, fmt.Sprintf(claimRequestsSQL, condition), args...)
+	return requestIDs, err
+}
+
+// releaseRequests gives up this instance's claims on requests. Requests that were not sent go back to the queue
+func releaseRequests(db sqlx.Execer, requestIDs ...int) {
+	_, err := db.Exec(`
+	UPDATE requests SET status = CASE WHEN status = 'inprogress' THEN 'ready' ELSE status END,
+		locked_by = NULL, lease_expires_at = NULL
+	WHERE id = ANY($1) AND locked_by = $2`, pq.Array(requestIDs), InstanceID())
+	if err != nil {
+		log.WithError(err).WithField("requests", requestIDs).Error("Failed to release requests")
+	}
+}
+
+// releaseInstanceClaims gives up all of this instance's claims on requests
+func releaseInstanceClaims(db *sqlx.DB) {
+	result, err := db.Exec(`
+	UPDATE requests SET status = 'ready', locked_by = NULL, lease_expires_at = NULL
+	WHERE status = 'inprogress' AND locked_by = $1`, InstanceID())
+	if err != nil {
+		log.WithError(err).Error("Failed to release claimed requests")
+		return
+	}
+	if released, _ := result.RowsAffected(); released > 0 {
+		log.WithField("requests", released).Info("Released claimed requests back to the queue")
+	}
+}
+
+// ReapExpiredLeases hands requests whose claims have expired, e.g. those of an instance that died,
+// back to the queue
+func ReapExpiredLeases(db *sqlx.DB) {
+	result, err := db.Exec(`
+	UPDATE requests SET status = 'ready', locked_by = NULL, lease_expires_at = NULL
+	WHERE status = 'inprogress' AND lease_expires_at < NOW()`)
+	if err != nil {
+		log.WithError(err).Error("Failed to reap expired request leases")
+		return
+	}
+	if reaped, _ := result.RowsAffected(); reaped > 0 {
+		log.WithField("requests", reaped).Warn("Returned requests with expired leases to the queue")
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/base64"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +18,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -32,11 +32,18 @@ type ServerStatus struct {
 	StatusCode string               `json:"statuscode,omitempty"`
 	Response   string               `json:"response,omitempty"`
 	Errors     string               `json:"errors"`
+	// FailureClass is the class of the last failure, if any
+	FailureClass FailureClass `json:"failureClass,omitempty"`
+	// RetryAfter is the least wait before retrying a failure as asked for by the server
+	RetryAfter time.Duration `json:"-"`
 }
 
 // AddParamsToURL takes a URL and add extra parameters to it from dbutils.MapAnything
 // check whether URL doesn't contain ? at the end before adding parameters, if so simply add parameters
 func AddParamsToURL(myURL string, params dbutils.MapAnything) string {
+	if len(params) == 0 {
+		return myURL
+	}
 	if !strings.HasSuffix(myURL, "?") {
 		myURL = myURL + "?"
 	}
@@ -78,11 +85,24 @@ type RequestObject struct {
 	Status             models.RequestStatus `db:"status"`
 	StatusCode         string               `db:"statuscode"`
 	Errors             string               `db:"errors"`
+	NextAttemptAt      models.NullTime      `db:"next_attempt_at"`
+	FailureClass       FailureClass         `db:"failure_class"`
+	retryAfter         time.Duration        // the least wait before the next attempt as asked for by the server
+	ctx                context.Context      // cancelled when sends still in flight must be abandoned
+}
+
+// sendContext returns the context under which the request is sent
+func (r *RequestObject) sendContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 const updateRequestSQL = `
-UPDATE requests SET (status, statuscode, errors, retries, response, updated)
-	= (:status, :statuscode, :errors, :retries, :response, current_timestamp) WHERE id = :id
+UPDATE requests SET (status, statuscode, errors, retries, response, next_attempt_at, failure_class, updated)
+	= (:status, :statuscode, :errors, :retries, :response, :next_attempt_at, :failure_class, current_timestamp)
+	WHERE id = :id
 `
 const updateStatusSQL = `
 	UPDATE requests SET (status,  updated) = (:status, current_timestamp)
@@ -90,7 +110,7 @@ const updateStatusSQL = `
 
 const selectRequestObjectSQL = `
 SELECT id, source, destination, depends_on, cc_servers, cc_servers_status, body, 
-	response, retries, ctype, object_type, body_is_query_param, submissionid, 
+	response, retries, content_type, object_type, body_is_query_param, submissionid, 
 	url_suffix, suspended, status, statuscode, errors
 FROM requests WHERE id = $1;`
 
@@ -123,14 +143,30 @@ func GetRequestObjectById(db *sqlx.DB, id models.RequestID) (*RequestObject, err
 	return &request, nil
 }
 
-// updateRequest is used by consumers to update request in the db
+// updateRequest is used by consumers to update request in the db and notify its source of final statuses
 func (r *RequestObject) updateRequest(tx *sqlx.Tx) {
+	if r.saveRequest(tx) {
+		_ = models.QueueRequestCallback(tx, r.ID, r.Source, r.Status, r.Errors)
+	}
+}
+
+// saveRequest updates the request in the db without notifying its source, returning whether it was updated
+func (r *RequestObject) saveRequest(tx *sqlx.Tx) bool {
+	r.NextAttemptAt = models.NullTime{}
+	if r.Status == models.RequestStatusFailed {
+		if destination, ok := models.ServerMap[fmt.Sprintf("%d", r.Destination)]; ok {
+			r.NextAttemptAt.Time = time.Now().Add(max(destination.RetryBackoff(r.Retries), r.retryAfter))
+			r.NextAttemptAt.Valid = true
+		}
+	} else if r.Status == models.RequestStatusCompleted {
+		r.FailureClass = ""
+	}
 	_, err := tx.NamedExec(updateRequestSQL, r)
 	if err != nil {
 		log.WithError(err).Error("Error updating request status")
+		return false
 	}
-
-	// _ = db.Commit()
+	return true
 }
 
 // updateCCServerStatus updates the status for CC servers on the request
@@ -142,12 +178,116 @@ func (r *RequestObject) updateCCServerStatus(tx *sqlx.Tx) {
 	log.WithFields(log.Fields{"ReqID": r.ID, "ServerStatus": r.CCServersStatus}).Info(">>>>>>>>>>>>>>")
 }
 
+// setCCServerStatus records the status of the request on one of its CC servers.
+// status.Retries is added to the retries already made to the server
+func (r *RequestObject) setCCServerStatus(tx *sqlx.Tx, server models.Server, status ServerStatus) {
+	r.setCCStatus(tx, fmt.Sprintf("%d", server.ID()), server, status)
+}
+
+// setCCStatus records the status of the request on the CC server or URL kept under key.
+// Failures are next retried after server's backoff
+func (r *RequestObject) setCCStatus(tx *sqlx.Tx, key string, server models.Server, status ServerStatus) {
+	if r.CCServersStatus == nil {
+		r.CCServersStatus = dbutils.MapAnything{}
+	}
+	if previous, ok := r.CCServersStatus[key].(map[string]interface{}); ok {
+		switch retries := previous["retries"].(type) {
+		case float64:
+			status.Retries += int(retries)
+		case int:
+			status.Retries += retries
+		}
+	}
+	nextAttemptAt := ""
+	if status.Status == models.RequestStatusFailed {
+		nextAttemptAt = time.Now().Add(max(server.RetryBackoff(status.Retries), status.RetryAfter)).Format(time.RFC3339)
+	}
+	r.CCServersStatus[key] = map[string]interface{}{
+		"status":        string(status.Status),
+		"statusCode":    status.StatusCode,
+		"errors":        status.Errors,
+		"retries":       status.Retries,
+		"nextAttemptAt": nextAttemptAt,
+		"failureClass":  string(status.FailureClass),
+	}
+	r.updateCCServerStatus(tx)
+}
+
+// recordFailure records a failed attempt at sending the request to destination. Retryable failures are
+// retried after the destination's backoff or the wait asked for by the server if longer, while permanent
+// failures put the request, or its copy to a CC server, in the terminal error status
+func (r *RequestObject) recordFailure(tx *sqlx.Tx, destination models.Server, serverInCC bool, failure Failure) {
+	status := models.RequestStatusFailed
+	if !failure.Class.Retryable() {
+		status = models.RequestStatusError
+	}
+	log.WithFields(log.Fields{
+		"requestID": r.ID, "serverID": destination.ID(), "serverInCC": serverInCC,
+		"failureClass": failure.Class, "statusCode": failure.StatusCode, "status": status,
+	}).Warn("Failed to send request")
+	if serverInCC {
+		r.setCCServerStatus(tx, destination, ServerStatus{
+			Status: status, StatusCode: failure.StatusCode, Errors: failure.Errors, Retries: 1,
+			FailureClass: failure.Class, RetryAfter: failure.RetryAfter})
+		return
+	}
+	r.Status = status
+	r.StatusCode = failure.StatusCode
+	r.Errors = failure.Errors
+	r.FailureClass = failure.Class
+	r.Retries += 1
+	if len(failure.Response) > 0 {
+		r.Response = failure.Response
+	}
+	r.retryAfter = failure.RetryAfter
+	r.updateRequest(tx)
+}
+
+// holdBack puts off sending the request to a destination that is throttled or whose circuit breaker is open
+// until retryAt without counting it as a retry. Copies to CC servers are simply tried again on the next retry run
+func (r *RequestObject) holdBack(tx *sqlx.Tx, destination models.Server, serverInCC bool, retryAt time.Time, reason string) {
+	log.WithFields(log.Fields{
+		"requestID": r.ID, "serverID": destination.ID(), "serverInCC": serverInCC, "retryAt": retryAt,
+		"reason": reason,
+	}).Info("Holding back request")
+	if serverInCC {
+		return
+	}
+	_, err := tx.Exec(`UPDATE requests SET next_attempt_at = $1 WHERE id = $2`, retryAt, r.ID)
+	if err != nil {
+		log.WithError(err).WithField("requestID", r.ID).Error("Failed to hold back request")
+	}
+}
+
+// recordBreakerOutcome records in the destination's circuit breaker whether the destination handled a request.
+// Responses rejecting the request itself show the destination is up, while requests that could not be built
+// or given credentials never reached it
+func recordBreakerOutcome(breaker *models.CircuitBreaker, resp *http.Response, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrAuthorization):
+		breaker.Release()
+	case err != nil:
+		breaker.Failure()
+	case resp.StatusCode/100 != 2 && ClassifyStatus(resp.StatusCode).Retryable():
+		breaker.Failure()
+	default:
+		breaker.Success()
+	}
+}
+
 // updateRequestStatus
 func (r *RequestObject) updateRequestStatus(tx *sqlx.Tx) {
 	_, err := tx.NamedExec(updateStatusSQL, r)
 	if err != nil {
 		log.WithError(err).Error("Error updating request")
+		return
 	}
+	_ = models.QueueRequestCallback(tx, r.ID, r.Source, r.Status, r.Errors)
+}
+
+// attemptDue returns whether the time for the next attempt at sending a failed request has come
+func (r *RequestObject) attemptDue() bool {
+	return !r.NextAttemptAt.Valid || !r.NextAttemptAt.Time.After(time.Now())
 }
 
 // WithStatus updates the RequestObj status with passed value
@@ -212,9 +352,11 @@ func (r *RequestObject) canSendRequest(tx *sqlx.Tx, server models.Server, server
 		// check if body is empty
 		if len(strings.TrimSpace(r.Body)) == 0 {
 			reason = "Request has empty body."
-			r.Status = models.RequestStatusFailed
+			// no retry will ever give it a body
+			r.Status = models.RequestStatusError
 			r.StatusCode = "ERROR1"
 			r.Errors = "Request has empty body"
+			r.FailureClass = FailurePermanent
 			r.updateRequest(tx)
 			log.WithFields(log.Fields{
 				"request": r.ID,
@@ -338,6 +480,7 @@ func (r *RequestObject) canSendRequest(tx *sqlx.Tx, server models.Server, server
 
 }
 
+// unMarshalBody decodes a JSON body for the transformations that need to work on its contents
 func (r *RequestObject) unMarshalBody() (interface{}, error) {
 	var data interface{}
 	switch r.ObjectType {
@@ -358,139 +501,166 @@ func (r *RequestObject) unMarshalBody() (interface{}, error) {
 	return data, nil
 }
 
-// sendRequest sends request to destination server
-func (r *RequestObject) sendRequest(destination models.Server) (*http.Response, error) {
-	data, err := r.unMarshalBody()
+// sendRequest sends request to destination server as built by the destination's adapter.
+// The body is first reshaped by the transforms of the route from the request's source to the destination
+func (r *RequestObject) sendRequest(tx *sqlx.Tx, adapter DestinationAdapter, destination models.Server) (*http.Response, error) {
+	route, err := models.ActiveRoute(tx, int64(r.Source), int64(destination.ID()))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to look up route: %w", err)
+	}
+	routed := *r
+	switch {
+	case route == nil:
+	case route.AppliesTo(r.ContentType, r.BodyIsQueryParams):
+		if routed.Body, err = route.Transforms.Apply(r.Body); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	case len(route.Transforms) > 0:
+		log.WithFields(log.Fields{"requestID": r.ID, "routeID": route.ID, "contentType": r.ContentType}).Warn(
+			"Sending request unchanged as route transforms only apply to JSON bodies")
 	}
-	marshalled, err := json.Marshal(data)
+	req, err := adapter.BuildRequest(&routed, destination)
 	if err != nil {
-		fmt.Printf("Failed to marshal request body")
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
-	destURL := destination.URL()
-	if len(r.URLSurffix) > 1 {
-		destURL += r.URLSurffix
+	req = req.WithContext(r.sendContext())
+	authProvider := destination.AuthProvider()
+	if err := authProvider.Authorize(req); err != nil {
+		return nil, fmt.Errorf("%w to server %d: %w", ErrAuthorization, destination.ID(), err)
 	}
-	completeURL := AddParamsToURL(destURL, destination.URLParams())
 	log.WithFields(log.Fields{
 		"request": r.ID,
 		"server":  destination.ID(),
-		"url":     completeURL,
+		"url":     req.URL.String(),
 	}).Info("Sending request to destination server")
-	req, err := http.NewRequest(destination.HTTPMethod(), completeURL, bytes.NewReader(marshalled))
-
-	switch destination.AuthMethod() {
-	case "Token":
-		// Add API token
-		tokenAuth := "ApiToken " + destination.AuthToken()
-		req.Header.Set("Authorization", tokenAuth)
-		log.WithField("AuthToken", tokenAuth).Info("The authentication token:")
-	default: // Basic Auth
-		// Add basic authentication
-		auth := destination.Username() + ":" + destination.Password()
-		basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-		req.Header.Set("Authorization", basicAuth)
-
-	}
-
-	req.Header.Set("Content-Type", r.ContentType)
-	// Create custom transport with TLS settings
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// Set any necessary TLS settings here
-			// For example, to disable certificate validation:
-			InsecureSkipVerify: true,
-		},
-	}
 
-	client := &http.Client{Transport: tr}
-
-	resp, err := client.Do(req)
+	client, err := destination.HTTPClient()
 	if err != nil {
 		return nil, err
 	}
-	return resp, nil
+	resp, err := client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		authProvider.Unauthorized(req)
+	}
+	return resp, err
 }
 
 // var RequestsMap = make(map[string]int)
 
-// Produce gets all the ready requests in the queue
-func Produce(db *sqlx.DB, jobs chan<- int, wg *sync.WaitGroup, mutex *sync.Mutex, seenMap map[models.RequestID]bool) {
+// requestsChannel is the channel on which the database notifies us of requests that are ready to send
+const requestsChannel = "dispatcher2_requests"
+
+const nextDueRequestSQL = `SELECT MIN(next_attempt_at) FROM requests WHERE status = 'ready' AND next_attempt_at > NOW()`
+
+// Produce claims ready requests for the consumers as the database notifies us of them. The queue is also swept
+// every request_sweep_interval, or sooner when a held back request falls due, for requests whose next attempt
+// has become due, whose notifications were missed or whose leases expired. Requests are claimed with
+// FOR UPDATE SKIP LOCKED so that several dispatchers can share a database.
+//
+// Claiming stops once ctx is done, when requests claimed but not yet handed to a consumer are released
+// and jobs is closed.
+func Produce(ctx context.Context, db *sqlx.DB, jobs chan<- int, wg *sync.WaitGroup) {
 	defer wg.Done()
-	log.Println("Producer staring:!!!")
+	defer close(jobs)
+	log.WithField("instance", InstanceID()).Info("Request producer starting")
 
-	// RequestsMap[""] = 6
-	for {
+	listener := pq.NewListener(config.Dispatcher2Conf.Database.URI, 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.WithError(err).Error("Request notifications listener failed")
+			}
+		})
+	defer func() { _ = listener.Close() }()
+	if err := listener.Listen(requestsChannel); err != nil {
+		log.WithError(err).Error("Failed to listen for request notifications, relying on sweeps")
+	}
 
-		log.Println("Going to read requests")
-		rows, err := db.Queryx(`
-                SELECT 
-                    id FROM requests 
-                WHERE status = $1  and status_of_dependence(id) IN ('completed', '') 
-                ORDER BY depends_on desc, created LIMIT 100000
-                `, "ready")
-		if err != nil {
-			log.WithError(err).Error("ERROR READING READY REQUESTS!!!")
+	handOver := func(requestIDs []int) {
+		for i, id := range requestIDs {
+			select {
+			case jobs <- id:
+			case <-ctx.Done():
+				releaseRequests(db, requestIDs[i:]...)
+				return
+			}
 		}
-		requestsCount := 0
-		for rows.Next() {
+	}
 
-			requestsCount += 1
-			var requestID int
-			err := rows.Scan(&requestID)
-			if err != nil {
-				// log.Fatalln("==>", err)
-				log.WithError(err).Error("Error reading request from queue:")
+	batchSize := max(config.Dispatcher2Conf.Server.MaxConcurrent, 1)
+	sweep := time.NewTimer(0)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Request producer stopped")
+			return
+		case notification := <-listener.Notify:
+			if notification == nil { // the listener reconnected and may have missed notifications
+				sweep.Reset(0)
+				continue
 			}
-			mutex.Lock()
-			if _, exists := seenMap[models.RequestID(requestID)]; exists {
-				log.WithField("requestID", requestID).Info("Request already in dynamic queue")
+			requestID, err := strconv.Atoi(notification.Extra)
+			if err != nil {
+				log.WithField("payload", notification.Extra).Error("Invalid request notification")
 				continue
 			}
-			mutex.Unlock()
-			go func(req int) {
-				// Let see if we can recover from panics XXX
-				defer func() {
-					if r := recover(); r != nil {
-						fmt.Println("Recovered in Produce", r)
-
-					}
-				}()
-				mutex.Lock()
-				defer mutex.Unlock()
-				//if _, exists := seenMap[models.RequestID(req)]; exists {
-				//	log.WithField("requestID", req).Info("Request already in dynamic queue")
-				//	return
-				//}
-				jobs <- req
-				seenMap[models.RequestID(req)] = true
-				log.Info(fmt.Sprintf("Added Request [id: %v]", req))
-			}(requestID)
-
-		}
-		if err := rows.Err(); err != nil {
-			log.WithError(err).Error("Error reading requests")
+			// requests waiting on a dependency or held back are left to a sweep
+			requestIDs, err := ClaimReadyRequests(db, 1, requestID)
+			if err != nil {
+				log.WithError(err).WithField("requestID", requestID).Error("Failed to claim request")
+			}
+			handOver(requestIDs)
+		case <-sweep.C:
+			ReapExpiredLeases(db)
+			requestIDs, err := ClaimReadyRequests(db, batchSize, 0)
+			if err != nil {
+				log.WithError(err).Error("ERROR CLAIMING READY REQUESTS!!!")
+			}
+			if len(requestIDs) > 0 {
+				log.WithField("requests", len(requestIDs)).Info("Claimed ready requests")
+			}
+			handOver(requestIDs)
+			sweep.Reset(nextSweepIn(db, len(requestIDs) == batchSize))
 		}
-		_ = rows.Close()
+	}
+}
 
-		if requestsCount > 0 {
-			log.WithField("requestsAdded", requestsCount).Info("Fetched Requests")
+// nextSweepIn returns how long until the next sweep of the queue. A full batch is followed at once by
+// another as more requests may be waiting, otherwise the sweep interval is cut short by the next held back
+// request to fall due
+func nextSweepIn(db *sqlx.DB, fullBatch bool) time.Duration {
+	if fullBatch {
+		return 0
+	}
+	interval := time.Duration(config.Dispatcher2Conf.Server.RequestSweepInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	var nextDue sql.NullTime
+	if err := db.Get(&nextDue, nextDueRequestSQL); err != nil {
+		log.WithError(err).Error("Failed to read when the next held back request is due")
+		return interval
+	}
+	if nextDue.Valid {
+		if wait := time.Until(nextDue.Time); wait < interval {
+			return max(wait, 0)
 		}
-		log.Info(fmt.Sprintf("Requests producer going to sleep for: %v", config.Dispatcher2Conf.Server.RequestProcessInterval))
-		// Not good enough but let's bare with the sleep this initial version
-		time.Sleep(
-			time.Duration(config.Dispatcher2Conf.Server.RequestProcessInterval) * time.Second)
 	}
+	return interval
 }
 
-// Consume is the consumer go routine
-func Consume(db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup, mutex *sync.RWMutex, seenMap map[models.RequestID]bool) {
+// Consume is the consumer go routine. Once ctx is done requests not yet started are released, while those
+// in flight are sent until drainCtx is done after which they are abandoned and released too.
+func Consume(ctx, drainCtx context.Context, db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer func() { _ = db.Close() }()
 	fmt.Println("Calling Consumer")
 
 	for req := range jobs {
+		if ctx.Err() != nil {
+			releaseRequests(db, req)
+			continue
+		}
 		fmt.Printf("Message %v is consumed by worker %v.\n", req, worker)
 
 		reqObj := RequestObject{}
@@ -502,11 +672,14 @@ func Consume(db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup, mutex
                         statuscode, status, errors
                         
                 FROM requests
-                WHERE id = $1 FOR UPDATE NOWAIT`, req).StructScan(&reqObj)
+                WHERE id = $1 AND status = 'inprogress' AND locked_by = $2 FOR UPDATE`, req, InstanceID()).StructScan(&reqObj)
 		if err != nil {
-			log.WithError(err).Error("Error reading request for processing")
-			return
+			// the lease expired and the request was handed to another instance
+			log.WithError(err).WithField("requestID", req).Error("Error reading request for processing")
+			_ = tx.Rollback()
+			continue
 		}
+		reqObj.ctx = drainCtx
 		log.WithFields(log.Fields{
 			"worker":    worker,
 			"requestID": req}).Info("Handling Request")
@@ -538,21 +711,20 @@ func Consume(db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup, mutex
 				return nil
 			})
 		}
+		if drainCtx.Err() != nil {
+			// sends were cut short by the drain deadline, so leave the request as it was
+			_ = tx.Rollback()
+			releaseRequests(db, req)
+			log.WithField("requestID", req).Warn("Released request unfinished at the drain deadline")
+			continue
+		}
+		releaseRequests(tx, req)
 
 		err = tx.Commit()
 		if err != nil {
 			log.WithError(err).Error("Failed to Commit transaction after processing!")
 		}
-		mutex.Lock()
-		delete(seenMap, models.RequestID(req))
-		log.WithFields(log.Fields{
-			"requestID":     req,
-			"seenMapLength": len(seenMap),
-			// "senMap":        seenMap,
-		}).Info("Consumer done with request.")
-		mutex.Unlock()
-		// delete(RequestsMap, fmt.Sprintf("Req-%d", req))
-		time.Sleep(1 * time.Second)
+		log.WithField("requestID", req).Info("Consumer done with request.")
 	}
 
 }
@@ -561,110 +733,95 @@ func Consume(db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup, mutex
 func ProcessRequest(tx *sqlx.Tx, reqObj RequestObject, destination models.Server, serverInCC, skipCheck bool) error {
 	if skipCheck || reqObj.canSendRequest(tx, destination, serverInCC) {
 		log.WithFields(log.Fields{"requestID": reqObj.ID}).Info("Request can be processed")
+		// copies to the destination's CC URLs go out once the destination has been sent to and its send slot
+		// and circuit breaker trial are given back, so that slow CC URLs don't hold up the destination
+		copiesDue := false
+		if !serverInCC {
+			defer func() {
+				if copiesDue {
+					reqObj.sendCopies(tx, destination)
+				}
+			}()
+		}
 		// send request
-		resp, err := reqObj.sendRequest(destination)
+		adapter := GetDestinationAdapter(destination.SystemType())
+		if allowed, retryAt := models.AcquireSendSlot(destination); !allowed {
+			reqObj.holdBack(tx, destination, serverInCC, retryAt, "Destination throttled")
+			return nil
+		}
+		defer models.ReleaseSendSlot(destination)
+		breaker := models.GetCircuitBreaker(destination.ID())
+		if allowed, retryAt := breaker.Allow(); !allowed {
+			reqObj.holdBack(tx, destination, serverInCC, retryAt, "Circuit breaker open")
+			return nil
+		}
+		copiesDue = !serverInCC
+		resp, err := reqObj.sendRequest(tx, adapter, destination)
+		recordBreakerOutcome(breaker, resp, err)
 		if err != nil {
 			log.WithError(err).WithField("RequestID", reqObj.ID).Error(
 				"Failed to send request")
-			reqObj.Status = models.RequestStatusFailed
-			reqObj.StatusCode = "ERROR02"
-			reqObj.Errors = "Server possibly unreachable"
-			reqObj.Retries += 1
-			reqObj.updateRequest(tx)
+			failure := Failure{Class: ClassifyError(err), StatusCode: "ERROR02", Errors: "Server possibly unreachable"}
+			var tokenErr *models.TokenError
+			switch {
+			case errors.Is(err, ErrInvalidRequest):
+				failure.StatusCode = "ERROR01"
+				failure.Errors = err.Error()
+			case errors.As(err, &tokenErr):
+				failure.StatusCode = fmt.Sprintf("%d", tokenErr.StatusCode)
+				failure.Errors = err.Error()
+			case errors.Is(err, ErrAuthorization):
+				failure.StatusCode = "ERROR05"
+				failure.Errors = err.Error()
+			}
+			reqObj.recordFailure(tx, destination, serverInCC, failure)
 			return err
 		}
 
 		if !destination.UseAsync() {
-			result := models.ImportSummary{}
 			respBody, _ := io.ReadAll(resp.Body)
-			err := json.Unmarshal(respBody, &result)
-			// err := json.NewDecoder(resp.Body).Decode(&result)
+			result, err := InterpretDestinationResponse(
+				adapter, destination, resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
 			if err != nil {
-				if serverInCC {
-					serverStatus := reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())].(map[string]interface{})
-					summary := "Failed to decode import summary"
-					newServerStatus := make(map[string]interface{})
-					newServerStatus["errors"] = summary
-					newServerStatus["status"] = models.RequestStatusFailed
-					newServerStatus["statusCode"] = "ERROR03"
-					newServerStatus["retries"] = int(serverStatus["retries"].(float64) + 1)
-					reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())] = newServerStatus
-					reqObj.updateCCServerStatus(tx)
-					// _, _ = tx.NamedExec(`UPDATE requests SET cc_servers_status = :cc_servers_status WHERE id = :id`, reqObj)
-				} else {
-					reqObj.Status = models.RequestStatusFailed
-					reqObj.StatusCode = "ERROR03"
-					reqObj.Errors = "Failed to decode import summary"
-					reqObj.Retries += 1
-					reqObj.updateRequest(tx)
-					log.WithField("Resp", string(respBody)).WithError(err).Error("Failed to decode import summary")
-					return err
-				}
+				log.WithField("Resp", string(respBody)).WithError(err).Error("Failed to interpret destination response")
+				// a response we can't understand is only permanent if the status says so
+				reqObj.recordFailure(tx, destination, serverInCC, Failure{
+					Class: ClassifyStatus(resp.StatusCode), StatusCode: "ERROR03", Errors: err.Error(),
+					Response: string(respBody), RetryAfter: RetryAfter(resp)})
+				_ = resp.Body.Close()
+				return err
 			}
-			if resp.StatusCode/100 == 2 {
-
+			if result.Completed {
 				if serverInCC {
-					serverStatus := reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())].(map[string]interface{})
-					summary := fmt.Sprintf("Created: %d, Updated: %d", result.Response.Stats.Created, result.Response.Stats.Updated)
-					newServerStatus := make(map[string]interface{})
-					newServerStatus["errors"] = summary
-					newServerStatus["status"] = models.RequestStatusCompleted
-					newServerStatus["statusCode"] = fmt.Sprintf("%d", resp.StatusCode)
-					newServerStatus["retries"] = serverStatus["retries"]
-					reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())] = newServerStatus
-					// reqObj.updateCCServerStatus(tx)
-					_, _ = tx.NamedExec(`UPDATE requests SET cc_servers_status = :cc_servers_status WHERE id = :id`, reqObj)
-
+					reqObj.setCCServerStatus(tx, destination, ServerStatus{
+						Status:     models.RequestStatusCompleted,
+						StatusCode: fmt.Sprintf("%d", resp.StatusCode),
+						Errors:     result.Summary})
 				} else {
-					summary := fmt.Sprintf("Created: %d, Updated: %d", result.Response.Stats.Created, result.Response.Stats.Updated)
 					reqObj.StatusCode = fmt.Sprintf("%d", resp.StatusCode)
-					reqObj.Errors = summary
+					reqObj.Errors = result.Summary
 					reqObj.Retries += 1
 					reqObj.Status = models.RequestStatusCompleted
+					reqObj.Response = string(respBody)
 					reqObj.updateRequest(tx)
-					reqObj.WithStatus(models.RequestStatusCompleted).updateRequestStatus(tx)
 				}
 				log.WithFields(log.Fields{
-					"status":     result.Response.Status,
-					"created":    result.Response.Stats.Created,
-					"updated":    result.Response.Stats.Updated,
-					"total":      result.Response.Stats.Total,
+					"summary":    result.Summary,
+					"systemType": destination.SystemType(),
 					"serverDBId": destination.ID(),
 					"requestID":  reqObj.ID,
-					// "response": string(respBody),
 				}).Info("Request completed successfully!")
-				// reqObj.CCServersStatus.Scan()
-				return nil
 			} else {
 				log.WithFields(log.Fields{
 					"requestID": reqObj.ID, "responseStatus": resp.StatusCode, "ServerInCC": serverInCC,
 				}).Warn("A non 200 response")
-				if serverInCC {
-					serverStatus := reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())].(map[string]interface{})
-					// summary := fmt.Sprintf("Created: 0, Updated: 0")
-					newServerStatus := make(map[string]interface{})
-					newServerStatus["status"] = "failed"
-					newServerStatus["statusCode"] = fmt.Sprintf("%d", resp.StatusCode)
-					switch serverStatus["retries"].(type) {
-					case float64:
-						newServerStatus["retries"] = int(serverStatus["retries"].(float64) + 1)
-					case int:
-						newServerStatus["retries"] = serverStatus["retries"].(int) + 1
-					}
-					newServerStatus["errors"] = "server possibly unreachable"
-					reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())] = newServerStatus
-					_, _ = tx.NamedExec(`UPDATE requests SET cc_servers_status = :cc_servers_status WHERE id = :id`, reqObj)
-
-					// reqObj.updateCCServerStatus(tx)
-				} else {
-					reqObj.StatusCode = fmt.Sprintf("%d", resp.StatusCode)
-					reqObj.Status = models.RequestStatusFailed
-					reqObj.Errors = "request might have conflicts"
-					reqObj.Retries += 1
-					reqObj.Response = string(respBody)
-					reqObj.updateRequest(tx)
-					// reqObj.withStatus(models.RequestStatusFailed).updateRequestStatus(tx)
+				failureClass := ClassifyStatus(resp.StatusCode)
+				if resp.StatusCode/100 == 2 { // accepted but rejected by the server's response
+					failureClass = FailureValidation
 				}
+				reqObj.recordFailure(tx, destination, serverInCC, Failure{
+					Class: failureClass, StatusCode: fmt.Sprintf("%d", resp.StatusCode), Errors: result.Summary,
+					Response: string(respBody), RetryAfter: RetryAfter(resp)})
 			}
 		} else {
 			// We are using Async
@@ -723,37 +880,18 @@ func ProcessRequest(tx *sqlx.Tx, reqObj RequestObject, destination models.Server
 					reqObj.Errors = summary
 					reqObj.Retries += 1
 					reqObj.Status = models.RequestStatusCompleted
-					reqObj.updateRequest(tx)
-					reqObj.WithStatus(models.RequestStatusCompleted).updateRequestStatus(tx)
+					// the source is notified once the async job's import summary is in
+					reqObj.saveRequest(tx)
 				}
 			} else {
 				log.WithFields(log.Fields{
 					"requestID": reqObj.ID, "responseStatus": resp.StatusCode, "ServerInCC": serverInCC,
 				}).Warn("A non 200 response from async request")
 
-				if serverInCC {
-					serverStatus := reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())].(map[string]interface{})
-					newServerStatus := make(map[string]interface{})
-					newServerStatus["status"] = "failed"
-					newServerStatus["statusCode"] = fmt.Sprintf("%d", resp.StatusCode)
-					switch serverStatus["retries"].(type) {
-					case float64:
-						newServerStatus["retries"] = int(serverStatus["retries"].(float64) + 1)
-					case int:
-						newServerStatus["retries"] = serverStatus["retries"].(int) + 1
-					}
-					newServerStatus["errors"] = "server possibly unreachable"
-					reqObj.CCServersStatus[fmt.Sprintf("%d", destination.ID())] = newServerStatus
-					_, _ = tx.NamedExec(`UPDATE requests SET cc_servers_status = :cc_servers_status WHERE id = :id`, reqObj)
-
-				} else {
-					reqObj.StatusCode = fmt.Sprintf("%d", resp.StatusCode)
-					reqObj.Status = models.RequestStatusFailed
-					reqObj.Errors = "request might have conflicts while async request"
-					reqObj.Retries += 1
-					reqObj.Response = string(bodyBytes)
-					reqObj.updateRequest(tx)
-				}
+				reqObj.recordFailure(tx, destination, serverInCC, Failure{
+					Class: ClassifyStatus(resp.StatusCode), StatusCode: fmt.Sprintf("%d", resp.StatusCode),
+					Errors: "request might have conflicts while async request", Response: string(bodyBytes),
+					RetryAfter: RetryAfter(resp)})
 
 			}
 
@@ -772,7 +910,7 @@ func ProcessRequest(tx *sqlx.Tx, reqObj RequestObject, destination models.Server
 }
 
 // StartConsumers starts the consumer go routines
-func StartConsumers(jobs <-chan int, wg *sync.WaitGroup, mutex *sync.RWMutex, seedMap map[models.RequestID]bool) {
+func StartConsumers(ctx, drainCtx context.Context, jobs <-chan int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	dbURI := config.Dispatcher2Conf.Database.URI
@@ -782,49 +920,76 @@ func StartConsumers(jobs <-chan int, wg *sync.WaitGroup, mutex *sync.RWMutex, se
 
 		newConn, err := sqlx.Connect("postgres", dbURI)
 		if err != nil {
-			log.Fatalln("Request processor failed to connect to database: %v", err)
+			log.Fatalf("Request processor failed to connect to database: %v", err)
 		}
 		log.Info(fmt.Sprintf("Adding Request Consumer: %d\n", i))
 		wg.Add(1)
-		go Consume(newConn, i, jobs, wg, mutex, seedMap)
+		go Consume(ctx, drainCtx, newConn, i, jobs, wg)
 	}
 	log.WithFields(log.Fields{"MaxConsumers": config.Dispatcher2Conf.Server.MaxConcurrent}).Info("Created Consumers: ")
 }
 
+// incompleteRequestsConditions select the requests due for a retry to their destination, CC servers or CC URLs
+const incompleteRequestsConditions = `
+	    ((status IN ('completed', 'failed') AND failed_cc_servers(cc_servers, cc_servers_status) <> '{}')  
+	   	OR (status = 'completed' AND has_failed_cc_urls(cc_servers_status, $1))
+	   	OR (status = 'failed' AND (next_attempt_at IS NULL OR next_attempt_at <= NOW()))) AND suspended = 0 AND status <> 'expired'`
+
 const incompleteRequestsSQL = `
-	SELECT id, destination, status, retries, failed_cc_servers(cc_servers, cc_servers_status) AS cc_servers, body,
-	       url_suffix, cc_servers_status, object_type, content_type, body_is_query_param
+	SELECT id FROM requests WHERE ` + incompleteRequestsConditions + ` ORDER by depends_on desc;
+`
+
+// claimIncompleteRequestSQL locks an incomplete request for its retry, skipping it if another retry run holds
+// it and checking again that it is still due once locked, as it may have been sent since it was listed
+const claimIncompleteRequestSQL = `
+	SELECT id, source, destination, status, retries, failed_cc_servers(cc_servers, cc_servers_status) AS cc_servers, body,
+	       url_suffix, cc_servers_status, object_type, content_type, body_is_query_param, next_attempt_at
 	FROM requests 
-	WHERE 
-	    ((status IN ('completed', 'failed') AND failed_cc_servers(cc_servers, cc_servers_status) <> '{}')  
-	   	OR status = 'failed') AND suspended = 0 AND status <> 'expired' ORDER by depends_on desc;
+	WHERE id = $2 AND ` + incompleteRequestsConditions + `
+	FOR UPDATE SKIP LOCKED;
 `
 
 // RetryIncompleteRequests is intended to occasionally retry incomplete requests - there could be a success chance
 // this could be scheduled to run every so often
-func RetryIncompleteRequests() {
+// No request is retried once ctx is done, while those being sent are given until drainCtx is done
+func RetryIncompleteRequests(ctx, drainCtx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
 	log.Info("..::::::.. Starting to process Incomplete Requests ..::::::..")
 	dbConn := db.GetDB()
-	rows, err := dbConn.Queryx(incompleteRequestsSQL)
+	var requestIDs []int64
+	err := dbConn.Select(&requestIDs, incompleteRequestsSQL, config.Dispatcher2Conf.Server.MaxRetries)
 	if err != nil {
 		log.WithError(err).Error("ERROR READING PREVIOUSLY INCOMPLETE REQUESTS!!!")
 		return
 	}
 
-	for rows.Next() {
-		reqObj := RequestObject{}
-		err := rows.StructScan(&reqObj)
+	for _, requestID := range requestIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		tx := dbConn.MustBegin()
+		reqObj := RequestObject{ctx: drainCtx}
+		err := tx.QueryRowx(claimIncompleteRequestSQL, config.Dispatcher2Conf.Server.MaxRetries, requestID).StructScan(&reqObj)
 		if err != nil {
-			log.WithError(err).Error("Error reading incomplete request for processing")
+			_ = tx.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				log.WithField("requestID", requestID).Debug("Incomplete request is no longer due or is being retried elsewhere")
+			} else {
+				log.WithError(err).Error("Error reading incomplete request for processing")
+			}
+			continue
 		}
 		log.WithFields(log.Fields{
 			"requestID": reqObj.ID}).Info("Handling Incomplete Request")
-		tx := dbConn.MustBegin()
 
 		if reqObj.Status == "failed" { // destination server request had failed
 			if reqDestination, ok := models.ServerMap[fmt.Sprintf("%d", reqObj.Destination)]; ok {
 				if reqObj.Retries <= config.Dispatcher2Conf.Server.MaxRetries {
-					_ = ProcessRequest(tx, reqObj, reqDestination, false, true)
+					if reqObj.attemptDue() {
+						_ = ProcessRequest(tx, reqObj, reqDestination, false, true)
+					}
 				} else {
 					reqObj.WithStatus(models.RequestStatusExpired).updateRequestStatus(tx)
 				}
@@ -841,6 +1006,9 @@ func RetryIncompleteRequests() {
 				})
 			}
 		} else {
+			if reqDestination, ok := models.ServerMap[fmt.Sprintf("%d", reqObj.Destination)]; ok {
+				reqObj.retryCopies(tx, reqDestination)
+			}
 			lo.Map(reqObj.CCServers, func(item int32, index int) error {
 				if ccServer, ok := models.ServerMap[fmt.Sprintf("%d", item)]; ok {
 					log.WithFields(log.Fields{"CCServerID": item, "ServerIndex": index}).Info(
@@ -873,7 +1041,6 @@ func RetryIncompleteRequests() {
 			log.WithError(err).Error("Failed to Commit transaction after processing incomplete request!")
 		}
 	}
-	_ = rows.Close()
 
 	log.Info("..:::.. Finished to process incomplete requests ..:::..")
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
@@ -21,73 +22,66 @@ func getDueSchedules(db *sqlx.DB) ([]int64, error) {
 	return ids, err
 }
 
-// ProduceSchedules a function that reads ready schedules by id from the database and sends the Id to a job channel for consumer to receive and process
+// ProduceSchedules a function that reads ready schedules by id from the database and sends the Id to a job channel for consumer to receive and process.
+// It stops once ctx is done, closing the job channel
 func ProduceSchedules(
+	ctx context.Context,
 	db *sqlx.DB,
 	jobs chan<- int64,
 	wg *sync.WaitGroup,
-	workingOnMutex *sync.Mutex,
+	workingOnMutex *sync.RWMutex,
 	workingOn map[int64]bool,
 ) {
 	defer wg.Done()
+	defer close(jobs)
 	log.Info("..:::.. Starting to produce due schedules..:::..")
 
 	for {
-		rows, err := db.Queryx(dueSchedulesSQL)
+		scheduleIDs, err := getDueSchedules(db)
 		if err != nil {
 			log.WithError(err).Error("ERROR READING READY SCHEDULES!!!")
 		}
-
-		var schedulesCount = 0
-		for rows.Next() {
-			schedulesCount += 1
-			var scheduleID int64
-			err = rows.Scan(&scheduleID)
-			if err != nil {
-				log.WithError(err).Error("Error reading schedule from queue:")
-			}
-			jobs <- scheduleID
+		for _, scheduleID := range scheduleIDs {
 			workingOnMutex.Lock()
 			if _, exists := workingOn[scheduleID]; exists {
+				workingOnMutex.Unlock()
 				log.WithField("scheduleID", scheduleID).Info("Schedule already in dynamic queue")
 				continue
 			}
+			workingOn[scheduleID] = true
 			workingOnMutex.Unlock()
-			go func(sched int64) {
-				// Let see if we can recover from panics XXX
-				defer func() {
-					if r := recover(); r != nil {
-						fmt.Println("Recovered in Produce", r)
-
-					}
-				}()
-				workingOnMutex.Lock()
-				defer workingOnMutex.Unlock()
-
-				jobs <- sched
-				workingOn[sched] = true
-				log.Info(fmt.Sprintf("Added Schedule [id: %v]", sched))
-			}(scheduleID)
-		}
-		if err := rows.Err(); err != nil {
-			log.WithError(err).Error("Error reading schedules")
+			select {
+			case jobs <- scheduleID:
+				log.Info(fmt.Sprintf("Added Schedule [id: %v]", scheduleID))
+			case <-ctx.Done():
+				log.Info("Schedule producer stopped")
+				return
+			}
 		}
-		_ = rows.Close()
-		if schedulesCount > 0 {
-			log.WithField("scheduleAdded", schedulesCount).Info("Fetched Schedules")
+		if len(scheduleIDs) > 0 {
+			log.WithField("scheduleAdded", len(scheduleIDs)).Info("Fetched Schedules")
 		}
 
-		// log.Info(fmt.Sprintf("Schedule producer going to sleep for: %v", config.AirQoIntegratorConf.Server.RequestProcessInterval))
-		time.Sleep(
-			time.Duration(config.Dispatcher2Conf.Server.RequestProcessInterval) * time.Second)
+		select {
+		case <-ctx.Done():
+			log.Info("Schedule producer stopped")
+			return
+		case <-time.After(time.Duration(config.Dispatcher2Conf.Server.RequestProcessInterval) * time.Second):
+		}
 	}
 
 }
 
-func ConsumeSchedules(db *sqlx.DB, jobs <-chan int64, wg *sync.WaitGroup, workingOnMutex *sync.RWMutex, workingOn map[int64]bool) {
+// ConsumeSchedules processes the schedules sent on jobs until it is closed. Schedules already running when
+// ctx is done are finished, with commands still running stopped once drainCtx is done
+func ConsumeSchedules(ctx, drainCtx context.Context, db *sqlx.DB, jobs <-chan int64, wg *sync.WaitGroup, workingOnMutex *sync.RWMutex, workingOn map[int64]bool) {
 	defer wg.Done()
+	defer func() { _ = db.Close() }()
 	for id := range jobs {
-		ProcessSchedule(db, id)
+		if ctx.Err() == nil {
+			ProcessSchedule(drainCtx, db, id)
+			time.Sleep(1 * time.Second)
+		}
 		workingOnMutex.Lock()
 		delete(workingOn, id)
 		log.WithFields(log.Fields{
@@ -96,17 +90,25 @@ func ConsumeSchedules(db *sqlx.DB, jobs <-chan int64, wg *sync.WaitGroup, workin
 			// "senMap":        seenMap,
 		}).Info("Consumer done with schedule.")
 		workingOnMutex.Unlock()
-		time.Sleep(1 * time.Second)
 	}
 }
 
-func ProcessSchedule(db *sqlx.DB, id int64) {
+// ProcessSchedule runs the schedule with id and records the outcome. Commands are stopped once ctx is done
+func ProcessSchedule(ctx context.Context, db *sqlx.DB, id int64) {
 	log.WithField("ScheduleID", id).Info("Processing Schedule")
 	schedule, err := models.GetSchedule(db, id)
 	if err != nil {
 		log.WithError(err).Error("Failed to fetch schedule")
 		return
 	}
+	// commands may run for minutes, so they are run before the transaction recording their outcome is opened
+	var commandRun models.ScheduleRun
+	var commandErr error
+	if schedule.ScheduleType == "command" {
+		log.WithFields(log.Fields{
+			"scheduleID": schedule.ID, "command": schedule.Command}).Info("Handling command schedule")
+		commandRun, commandErr = schedule.RunCommand(ctx)
+	}
 	tx, err := db.Beginx()
 	if err != nil {
 		log.Fatalln(err)
@@ -123,13 +125,18 @@ func ProcessSchedule(db *sqlx.DB, id int64) {
 		}
 	}()
 
+	run := models.NewScheduleRun(schedule.ID)
 	switch schedule.ScheduleType {
 	case "dhis2_async_job_check":
-		completed, exists, _ := models.CheckDhis2AsyncJobStatus(schedule)
+		completed, exists, er := models.CheckDhis2AsyncJobStatus(schedule)
+		if er != nil {
+			run.Errors = er.Error()
+		}
 		if completed {
 			taskSummary, err := models.CheckDhis2AsyncJobTaskSummary(tx, schedule)
 			if err != nil {
 				log.WithError(err).Errorf("Failed to check dhis2 async job: Schedule ID: %v", schedule.ID)
+				run.Finish(models.ScheduleRunFailed, "", err.Error())
 			} else {
 				schedule.Status = "completed"
 				schedule.Updated = time.Now().In(models.Location)
@@ -137,20 +144,24 @@ func ProcessSchedule(db *sqlx.DB, id int64) {
 				if err != nil {
 					log.WithError(err).Error("Failed to update schedule")
 				}
+				summary := fmt.Sprintf(
+					"Imported: %d, Updated: %d, Ignored: %d, Deleted: %d, Total: %d",
+					taskSummary.ImportCount.Imported,
+					taskSummary.ImportCount.Updated,
+					taskSummary.ImportCount.Ignored,
+					taskSummary.ImportCount.Deleted,
+					taskSummary.ImportCount.Total)
+				if taskSummary.Status == "SUCCESS" {
+					run.Finish(models.ScheduleRunSucceeded, summary, "")
+				} else {
+					run.Finish(models.ScheduleRunFailed, summary, fmt.Sprintf("%v", taskSummary.ImportConflicts))
+				}
 				// log.Infof("Schedule updated successfully: %v", taskSummary)
 				reqObj, er := GetRequestObjectById(db, *schedule.RequestID)
 				log.Infof("REQUEST OBJECT: %v, !Nil? %v", reqObj, reqObj != nil)
 				if er == nil && reqObj != nil {
 					if *schedule.ServerInCC {
-						log.Infof("XXXXXX")
 						serverStatus := reqObj.CCServersStatus[fmt.Sprintf("%d", reqObj.Destination)].(map[string]interface{})
-						summary := fmt.Sprintf(
-							"Imported: %d, Updated: %d, Ignored: %d, Deleted: %d, Total: %d",
-							taskSummary.ImportCount.Imported,
-							taskSummary.ImportCount.Updated,
-							taskSummary.ImportCount.Ignored,
-							taskSummary.ImportCount.Deleted,
-							taskSummary.ImportCount.Total)
 						newServerStatus := make(map[string]interface{})
 						newServerStatus["errors"] = summary
 						newServerStatus["status"] = models.RequestStatusCompleted
@@ -168,13 +179,7 @@ func ProcessSchedule(db *sqlx.DB, id int64) {
 						reqObj.Retries += 1
 						if taskSummary.Status == "SUCCESS" {
 							reqObj.Status = models.RequestStatusCompleted
-							reqObj.Errors = fmt.Sprintf(
-								"Imported: %d, Updated: %d, Ignored: %d, Deleted: %d, Total: %d",
-								taskSummary.ImportCount.Imported,
-								taskSummary.ImportCount.Updated,
-								taskSummary.ImportCount.Ignored,
-								taskSummary.ImportCount.Deleted,
-								taskSummary.ImportCount.Total)
+							reqObj.Errors = summary
 						} else {
 							reqObj.Status = models.RequestStatusFailed
 							reqObj.Errors = fmt.Sprintf("%v", taskSummary.ImportConflicts)
@@ -189,11 +194,17 @@ func ProcessSchedule(db *sqlx.DB, id int64) {
 		} else {
 			if exists { // perhaps async request removed from server
 				schedule.Status = "ready"
-				nextRun := time.Now().Add(
-					time.Second * time.Duration(config.Dispatcher2Conf.Server.Dhis2JobStatusCheckInterval))
+				nextRun, _, er := schedule.NextRun(time.Now())
+				if er != nil {
+					log.WithError(er).WithField("scheduleID", schedule.ID).Error("Failed to compute schedule next run")
+					nextRun = time.Now().Add(
+						time.Second * time.Duration(config.Dispatcher2Conf.Server.Dhis2JobStatusCheckInterval))
+				}
 				_ = schedule.SetNextRun(tx, nextRun)
+				run.Finish(models.ScheduleRunPending, "Async job not yet completed", run.Errors)
 			} else {
 				schedule.Status = "expired"
+				run.Finish(models.ScheduleRunExpired, "Async job not found on server", run.Errors)
 			}
 
 			schedule.Updated = time.Now().In(models.Location)
@@ -203,20 +214,78 @@ func ProcessSchedule(db *sqlx.DB, id int64) {
 			}
 		}
 	case "url":
-		log.Info("Handling URL schedule")
-	case "sms":
-		log.Info("Handling URL schedule")
-	case "contact_push":
-		log.Info("Handling contact push schedule")
+		log.WithFields(log.Fields{
+			"scheduleID": schedule.ID, "url": schedule.ScheduleURL}).Info("Handling URL schedule")
+		status := "sent"
+		resp, er := schedule.CallURL()
+		if er != nil {
+			log.WithError(er).WithField("scheduleID", schedule.ID).Error("Failed to call schedule URL")
+			status = "failed"
+			run.Finish(models.ScheduleRunFailed, "", er.Error())
+			err = schedule.SetResponse(tx, "ERROR02", er.Error())
+		} else {
+			run.Finish(models.ScheduleRunSucceeded, string(resp.Body()), "")
+			if !resp.IsSuccess() {
+				log.WithFields(log.Fields{
+					"scheduleID": schedule.ID, "responseStatus": resp.StatusCode(),
+				}).Warn("A non 200 response from schedule URL")
+				status = "failed"
+				run.Finish(models.ScheduleRunFailed, string(resp.Body()), fmt.Sprintf("HTTP %d", resp.StatusCode()))
+			}
+			err = schedule.SetResponse(tx, fmt.Sprintf("%d", resp.StatusCode()), string(resp.Body()))
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to save schedule response")
+		}
+
+		err = schedule.Reschedule(tx, status)
+		if err != nil {
+			log.WithError(err).WithField("scheduleID", schedule.ID).Error("Failed to reschedule schedule")
+		}
 	case "command":
-		log.Info("Handling command schedule")
-	default:
-		log.Info("Unknown schedule")
+		status := "sent"
+		run = commandRun
+		if commandErr != nil {
+			log.WithError(commandErr).WithFields(log.Fields{
+				"scheduleID": schedule.ID, "command": schedule.Command, "stderr": run.Stderr,
+			}).Error("Command schedule failed")
+			status = "failed"
+			run.Finish(models.ScheduleRunFailed, run.Stdout, commandErr.Error())
+		} else {
+			run.Finish(models.ScheduleRunSucceeded, run.Stdout, "")
+		}
+		exitCode := "ERROR04"
+		if run.ExitCode != nil {
+			exitCode = fmt.Sprintf("%d", *run.ExitCode)
+		}
+		err = schedule.SetResponse(tx, exitCode, run.Stdout)
+		if err != nil {
+			log.WithError(err).Error("Failed to save schedule response")
+		}
+
+		err = schedule.Reschedule(tx, status)
+		if err != nil {
+			log.WithError(err).WithField("scheduleID", schedule.ID).Error("Failed to reschedule schedule")
+		}
+	default: // sms, contact_push
+		log.WithFields(log.Fields{
+			"scheduleID": schedule.ID, "type": schedule.ScheduleType}).Info("Skipping unsupported schedule")
+		run.Finish(models.ScheduleRunSkipped, "",
+			fmt.Sprintf("schedules of type '%s' are not supported", schedule.ScheduleType))
+		// skip it so that it's not picked again on every produce
+		err = schedule.UpdateStatus(tx, "skipped")
+		if err != nil {
+			log.WithError(err).Error("Failed to update schedule status")
+		}
+	}
 
+	// the run's history is not worth losing the schedule's update for
+	if er := models.CreateScheduleRunTx(tx, run); er != nil {
+		log.WithError(er).WithField("scheduleID", schedule.ID).Warn("Schedule run not recorded")
 	}
 }
 
-func StartScheduleConsumers(scheduledJobs <-chan int64, wg *sync.WaitGroup, mutex *sync.RWMutex, workingOn map[int64]bool) {
+func StartScheduleConsumers(ctx, drainCtx context.Context, scheduledJobs <-chan int64, wg *sync.WaitGroup, mutex *sync.RWMutex, workingOn map[int64]bool) {
 	dbURI := config.Dispatcher2Conf.Database.URI
 	log.Info(fmt.Sprintf("Going to create %d Schedule Consumers. Timezone: %s!!!!!\n",
 		config.Dispatcher2Conf.Server.MaxConcurrent, config.Dispatcher2Conf.Server.TimeZone))
@@ -228,7 +297,7 @@ func StartScheduleConsumers(scheduledJobs <-chan int64, wg *sync.WaitGroup, mute
 		} else {
 			log.Info(fmt.Sprintf("Adding Schedule Consumer: %d\n", i))
 			wg.Add(1)
-			go ConsumeSchedules(newConn, scheduledJobs, wg, mutex, workingOn)
+			go ConsumeSchedules(ctx, drainCtx, newConn, scheduledJobs, wg, mutex, workingOn)
 			numConsumers++
 		}
 	}